@@ -0,0 +1,1163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snowflakedb/gosnowflake"
+	"github.com/youmark/pkcs8"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultLookback is the history window used when SNOWFLAKE_LOOKBACK is
+	// unset.
+	defaultLookback = 24 * time.Hour
+
+	// defaultCacheTTL is how long scraped metrics are reused when
+	// SNOWFLAKE_CACHE_TTL is unset.
+	defaultCacheTTL = 5 * time.Minute
+
+	// defaultQueryTimeout bounds each Snowflake query when
+	// SNOWFLAKE_QUERY_TIMEOUT is unset.
+	defaultQueryTimeout = 30 * time.Second
+
+	// defaultScrapeTimeout bounds a whole scrape when SCRAPE_TIMEOUT is
+	// unset. It stays under Prometheus's usual one minute scrape timeout
+	// so partial metrics are served before Prometheus gives up.
+	defaultScrapeTimeout = 50 * time.Second
+
+	// defaultQueryRetries is how many times a query failing with a
+	// transient error is retried when SNOWFLAKE_QUERY_RETRIES is unset.
+	// defaultRetryBackoff is the wait before the first retry.
+	defaultQueryRetries = 2
+	defaultRetryBackoff = time.Second
+
+	// defaultMaxConcurrentQueries caps the queries a collector runs at once
+	// when SNOWFLAKE_MAX_CONCURRENT_QUERIES is unset, keeping well clear of
+	// the warehouse's statement concurrency.
+	defaultMaxConcurrentQueries = 3
+
+	// defaultTableStorageLimit is how many of the largest tables the opt-in
+	// table_storage group exports when SNOWFLAKE_TABLE_STORAGE_LIMIT is
+	// unset.
+	defaultTableStorageLimit = 100
+
+	// defaultUsageSchema is the SNOWFLAKE schema the usage views are read
+	// from when SNOWFLAKE_USAGE_SCHEMA is unset.
+	defaultUsageSchema = "account_usage"
+
+	// defaultRefreshJitter is the fraction background refreshes are spread
+	// by when SNOWFLAKE_REFRESH_JITTER is unset.
+	defaultRefreshJitter = 0.1
+
+	// defaultQueryTag is the QUERY_TAG of the exporter's sessions when
+	// SNOWFLAKE_QUERY_TAG is unset.
+	defaultQueryTag = "snowflake-exporter"
+
+	// defaultPort is the port the exporter listens on when EXPORTER_PORT is
+	// unset.
+	defaultPort = "9090"
+
+	// defaultMetricsPath is the route metrics are served on when
+	// METRICS_PATH is unset.
+	defaultMetricsPath = "/metrics"
+
+	// HTTP server timeouts used when EXPORTER_READ_TIMEOUT,
+	// EXPORTER_WRITE_TIMEOUT and EXPORTER_IDLE_TIMEOUT are unset. The write
+	// timeout covers a scrape that misses the cache and waits for every
+	// query, so it is well above the query timeout.
+	defaultReadTimeout  = 10 * time.Second
+	defaultWriteTimeout = 2 * time.Minute
+	defaultIdleTimeout  = time.Minute
+
+	// shutdownTimeout bounds how long in-flight scrapes may take to finish
+	// once the exporter is asked to stop.
+	shutdownTimeout = 10 * time.Second
+
+	// Connection pool defaults. The exporter only runs a handful of queries
+	// per scrape, so a small pool is enough and avoids holding many Snowflake
+	// sessions open. Connections are recycled every few minutes so sessions
+	// that Snowflake has expired server-side are not reused.
+	defaultMaxOpenConns    = 5
+	defaultMaxIdleConns    = 5
+	defaultConnMaxLifetime = 5 * time.Minute
+)
+
+// Config is the exporter configuration. It is read from an optional YAML
+// file, with environment variables taking precedence over file values. On
+// SIGHUP it is read again and the query settings, such as metric groups,
+// cache TTLs and lookback, are applied without a restart; see reloadable.
+type Config struct {
+	Connection   connectionConfig `yaml:"connection"`
+	Pool         poolConfig       `yaml:"pool"`
+	Proxy        proxyConfig      `yaml:"proxy"`
+	ListenPort   string           `yaml:"listen_port"`
+	MetricsPath  string           `yaml:"metrics_path"`
+	CacheTTL     time.Duration    `yaml:"cache_ttl"`
+	QueryTimeout time.Duration    `yaml:"query_timeout"`
+	QueryRetries int              `yaml:"query_retries"`
+	Lookback     string           `yaml:"lookback"`
+	LogLevel     string           `yaml:"log_level"`
+	LogFormat    string           `yaml:"log_format"`
+
+	// LogFile, when set, is where logs are written instead of stderr. The
+	// file is appended to and reopened on SIGHUP, so it can be rotated by
+	// moving it aside and signalling the exporter.
+	LogFile string `yaml:"log_file"`
+
+	// MaxConcurrentQueries caps how many of an account's queries run at
+	// once.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries"`
+
+	// ScrapeTimeout bounds a whole scrape. Queries still running when it
+	// expires are cancelled and the metrics gathered so far are served.
+	ScrapeTimeout time.Duration `yaml:"scrape_timeout"`
+
+	// Accounts lists the connections of every account to export when more
+	// than one is monitored. Each entry is configured like Connection but is
+	// read from the file only; when set, Connection is ignored.
+	Accounts []connectionConfig `yaml:"accounts"`
+
+	// DisableAccountLabel drops the account label that is otherwise added
+	// to every metric. It has no effect with several accounts, whose series
+	// would collide without it.
+	DisableAccountLabel bool `yaml:"disable_account_label"`
+
+	// ExtraLabels are static labels added to every metric, such as env or
+	// team.
+	ExtraLabels map[string]string `yaml:"extra_labels"`
+
+	// CacheTTLs override CacheTTL for single metric groups, so groups backed
+	// by slow-moving views such as storage_bytes can be cached longer than
+	// query history. They are read from the file only and also apply with
+	// RefreshInterval: an overridden group is only queried again once its
+	// TTL has expired.
+	CacheTTLs map[string]time.Duration `yaml:"cache_ttls"`
+
+	// RefreshInterval, when set, refreshes metrics in the background on
+	// this interval instead of on scrape. CacheTTL is then unused.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+
+	// RefreshJitter, a fraction below 1, randomizes each background refresh
+	// interval by up to that much either way: 0.1 waits between 90% and
+	// 110% of RefreshInterval. Zero refreshes on a fixed interval.
+	RefreshJitter float64 `yaml:"refresh_jitter"`
+
+	// ListenAddress is a host:port to bind. It takes precedence over
+	// ListenPort, which binds all interfaces.
+	ListenAddress string `yaml:"listen_address"`
+
+	// ReadTimeout, WriteTimeout and IdleTimeout bound how long the HTTP
+	// server waits on a client, so stalled connections are dropped. Zero
+	// disables a timeout.
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+
+	// TLSCertFile and TLSKeyFile enable HTTPS on the metrics endpoint when
+	// both are set.
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+
+	// MetricsAuthUsername and MetricsAuthPassword require HTTP basic auth
+	// on the metrics endpoint when both are set.
+	MetricsAuthUsername string `yaml:"metrics_auth_username"`
+	MetricsAuthPassword string `yaml:"metrics_auth_password"`
+
+	// MetricsGzip gzips metrics responses for clients that accept it, which
+	// shrinks large payloads considerably. It is on by default.
+	MetricsGzip bool `yaml:"metrics_gzip"`
+
+	// DisableRuntimeMetrics drops the exporter's own go_* and process_*
+	// metrics from the metrics endpoint.
+	DisableRuntimeMetrics bool `yaml:"disable_runtime_metrics"`
+
+	// WarehouseFilter limits the per-warehouse credit and query count
+	// metrics to the listed warehouses. Empty means all warehouses.
+	WarehouseFilter []string `yaml:"warehouse_filter"`
+
+	// MetricGroups enables or disables metric groups by name. Groups that
+	// are not listed are enabled, except for the opt-in table_storage,
+	// logins_by_client_ip, remaining_balance and clustering_depth.
+	// METRICS_ENABLE_<GROUP> environment variables, such as
+	// METRICS_ENABLE_STORAGE_BYTES=false, override single groups.
+	MetricGroups map[string]bool `yaml:"metric_groups"`
+
+	// TableStorageLimit caps the tables exported by the table_storage
+	// group, which emits a series per table.
+	TableStorageLimit int `yaml:"table_storage_limit"`
+
+	// UsageSchema selects the SNOWFLAKE schema the credit, storage and
+	// transfer groups read from: account_usage, the default, reports on the
+	// connected account, and organization_usage consolidates every account
+	// in the organization for an account with ORGADMIN access. Series for
+	// objects of the same name in different accounts are summed. The views
+	// of organization_usage lag further behind, by up to a day. Groups
+	// without an organization-wide view, such as those reading
+	// query_history, always use account_usage.
+	UsageSchema string `yaml:"usage_schema"`
+
+	// ClusteringTables lists the tables, as DATABASE.SCHEMA.TABLE, whose
+	// clustering depth the opt-in clustering_depth group reports. The group
+	// runs one query per table and cannot be enabled without tables.
+	ClusteringTables []string `yaml:"clustering_tables"`
+
+	// QueryCountByUser adds a user_name label to snowflake_query_count.
+	// Leave it off on accounts with many users to bound the series count.
+	QueryCountByUser bool `yaml:"query_count_by_user"`
+
+	// Queries replaces the built-in query of a metric group, keyed by group
+	// name, for example to read from a custom view. A replacement must
+	// return the same columns in the same order as the query it replaces
+	// and takes no bind parameters, so the lookback window does not apply
+	// to it. The queries of table_storage and clustering_depth, and of
+	// warehouse_credits and query_count while WarehouseFilter is set, bind
+	// parameters and cannot be replaced. Rows that do not match are logged
+	// and counted as scrape errors.
+	Queries map[string]string `yaml:"queries"`
+
+	// CustomMetrics are additional gauges, each backed by its own query.
+	CustomMetrics []CustomMetric `yaml:"custom_metrics"`
+
+	// lookback is Lookback parsed by LoadConfig
+	lookback time.Duration
+}
+
+// LoadConfig reads the configuration from the YAML file at path, applies
+// environment variable overrides and validates the result. An empty path
+// loads the defaults and environment only.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{
+		Pool: poolConfig{
+			MaxOpenConns:    defaultMaxOpenConns,
+			MaxIdleConns:    defaultMaxIdleConns,
+			ConnMaxLifetime: defaultConnMaxLifetime,
+		},
+		ListenPort:   defaultPort,
+		MetricsPath:  defaultMetricsPath,
+		CacheTTL:     defaultCacheTTL,
+		QueryTimeout: defaultQueryTimeout,
+		QueryRetries: defaultQueryRetries,
+
+		MaxConcurrentQueries: defaultMaxConcurrentQueries,
+		ScrapeTimeout:        defaultScrapeTimeout,
+		TableStorageLimit:    defaultTableStorageLimit,
+		UsageSchema:          defaultUsageSchema,
+		RefreshJitter:        defaultRefreshJitter,
+
+		ReadTimeout:  defaultReadTimeout,
+		WriteTimeout: defaultWriteTimeout,
+		IdleTimeout:  defaultIdleTimeout,
+		MetricsGzip:  true,
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides configuration values with any environment variables
+// that are set.
+func (cfg *Config) applyEnv() error {
+	if err := cfg.Connection.applyEnv(); err != nil {
+		return err
+	}
+	setFromEnv(&cfg.ListenPort, "EXPORTER_PORT")
+	setFromEnv(&cfg.ListenAddress, "EXPORTER_LISTEN_ADDRESS")
+	setFromEnv(&cfg.MetricsPath, "METRICS_PATH")
+	setFromEnv(&cfg.Lookback, "SNOWFLAKE_LOOKBACK")
+	setFromEnv(&cfg.LogLevel, "LOG_LEVEL")
+	setFromEnv(&cfg.LogFormat, "LOG_FORMAT")
+	setFromEnv(&cfg.LogFile, "LOG_FILE")
+	setFromEnv(&cfg.TLSCertFile, "TLS_CERT_FILE")
+	setFromEnv(&cfg.TLSKeyFile, "TLS_KEY_FILE")
+	setFromEnv(&cfg.MetricsAuthUsername, "METRICS_AUTH_USERNAME")
+
+	if v := os.Getenv("SNOWFLAKE_WAREHOUSE_FILTER"); v != "" {
+		cfg.WarehouseFilter = splitList(v)
+	}
+	setFromEnv(&cfg.UsageSchema, "SNOWFLAKE_USAGE_SCHEMA")
+	if v := os.Getenv("SNOWFLAKE_CLUSTERING_TABLES"); v != "" {
+		cfg.ClusteringTables = splitList(v)
+	}
+
+	if err := setSecretFromEnv(&cfg.MetricsAuthPassword, "METRICS_AUTH_PASSWORD"); err != nil {
+		return err
+	}
+	if err := setSecretFromEnv(&cfg.Proxy.Password, "SNOWFLAKE_PROXY_PASSWORD"); err != nil {
+		return err
+	}
+
+	var err error
+	for _, name := range metricGroupNames() {
+		env := "METRICS_ENABLE_" + strings.ToUpper(name)
+		if os.Getenv(env) == "" {
+			continue
+		}
+		enabled, err := boolFromEnv(env, true)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", env, err)
+		}
+		if cfg.MetricGroups == nil {
+			cfg.MetricGroups = map[string]bool{}
+		}
+		cfg.MetricGroups[name] = enabled
+	}
+	if v := os.Getenv("EXTRA_LABELS"); v != "" {
+		if cfg.ExtraLabels, err = parseLabels(v); err != nil {
+			return fmt.Errorf("invalid EXTRA_LABELS: %v", err)
+		}
+	}
+	if cfg.MetricsGzip, err = boolFromEnv("METRICS_GZIP", cfg.MetricsGzip); err != nil {
+		return fmt.Errorf("invalid METRICS_GZIP: %v", err)
+	}
+	if cfg.DisableRuntimeMetrics, err = boolFromEnv("EXPORTER_DISABLE_RUNTIME_METRICS", cfg.DisableRuntimeMetrics); err != nil {
+		return fmt.Errorf("invalid EXPORTER_DISABLE_RUNTIME_METRICS: %v", err)
+	}
+	if cfg.DisableAccountLabel, err = boolFromEnv("SNOWFLAKE_DISABLE_ACCOUNT_LABEL", cfg.DisableAccountLabel); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_DISABLE_ACCOUNT_LABEL: %v", err)
+	}
+	if cfg.QueryCountByUser, err = boolFromEnv("SNOWFLAKE_QUERY_COUNT_BY_USER", cfg.QueryCountByUser); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_QUERY_COUNT_BY_USER: %v", err)
+	}
+	if cfg.CacheTTL, err = durationFromEnv("SNOWFLAKE_CACHE_TTL", cfg.CacheTTL); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_CACHE_TTL: %v", err)
+	}
+	if cfg.RefreshInterval, err = durationFromEnv("SNOWFLAKE_REFRESH_INTERVAL", cfg.RefreshInterval); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_REFRESH_INTERVAL: %v", err)
+	}
+	if cfg.RefreshJitter, err = floatFromEnv("SNOWFLAKE_REFRESH_JITTER", cfg.RefreshJitter); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_REFRESH_JITTER: %v", err)
+	}
+	if cfg.QueryTimeout, err = durationFromEnv("SNOWFLAKE_QUERY_TIMEOUT", cfg.QueryTimeout); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_QUERY_TIMEOUT: %v", err)
+	}
+	for _, timeout := range []struct {
+		value *time.Duration
+		name  string
+	}{
+		{&cfg.ReadTimeout, "EXPORTER_READ_TIMEOUT"},
+		{&cfg.WriteTimeout, "EXPORTER_WRITE_TIMEOUT"},
+		{&cfg.IdleTimeout, "EXPORTER_IDLE_TIMEOUT"},
+	} {
+		if *timeout.value, err = durationFromEnv(timeout.name, *timeout.value); err != nil {
+			return fmt.Errorf("invalid %s: %v", timeout.name, err)
+		}
+	}
+	if cfg.ScrapeTimeout, err = durationFromEnv("SCRAPE_TIMEOUT", cfg.ScrapeTimeout); err != nil {
+		return fmt.Errorf("invalid SCRAPE_TIMEOUT: %v", err)
+	}
+	if cfg.QueryRetries, err = intFromEnv("SNOWFLAKE_QUERY_RETRIES", cfg.QueryRetries); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_QUERY_RETRIES: %v", err)
+	}
+	if cfg.MaxConcurrentQueries, err = intFromEnv("SNOWFLAKE_MAX_CONCURRENT_QUERIES", cfg.MaxConcurrentQueries); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_MAX_CONCURRENT_QUERIES: %v", err)
+	}
+	if cfg.TableStorageLimit, err = intFromEnv("SNOWFLAKE_TABLE_STORAGE_LIMIT", cfg.TableStorageLimit); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_TABLE_STORAGE_LIMIT: %v", err)
+	}
+	if cfg.Pool.MaxOpenConns, err = intFromEnv("SNOWFLAKE_MAX_OPEN_CONNS", cfg.Pool.MaxOpenConns); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_MAX_OPEN_CONNS: %v", err)
+	}
+	if cfg.Pool.MaxIdleConns, err = intFromEnv("SNOWFLAKE_MAX_IDLE_CONNS", cfg.Pool.MaxIdleConns); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_MAX_IDLE_CONNS: %v", err)
+	}
+	if cfg.Pool.ConnMaxLifetime, err = durationFromEnv("SNOWFLAKE_CONN_MAX_LIFETIME", cfg.Pool.ConnMaxLifetime); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_CONN_MAX_LIFETIME: %v", err)
+	}
+
+	setFromEnv(&cfg.Proxy.Host, "SNOWFLAKE_PROXY_HOST")
+	setFromEnv(&cfg.Proxy.User, "SNOWFLAKE_PROXY_USER")
+	if cfg.Proxy.Port, err = intFromEnv("SNOWFLAKE_PROXY_PORT", cfg.Proxy.Port); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_PROXY_PORT: %v", err)
+	}
+	return nil
+}
+
+// validate checks the configuration for invalid values.
+func (cfg *Config) validate() error {
+	var err error
+	if cfg.lookback, err = parseLookback(cfg.Lookback); err != nil {
+		return err
+	}
+	if cfg.CacheTTL < 0 {
+		return fmt.Errorf("invalid cache_ttl %s: must not be negative", cfg.CacheTTL)
+	}
+	if cfg.RefreshInterval < 0 {
+		return fmt.Errorf("invalid refresh_interval %s: must not be negative", cfg.RefreshInterval)
+	}
+	if cfg.RefreshJitter < 0 || cfg.RefreshJitter >= 1 {
+		return fmt.Errorf("invalid refresh_jitter %g: must be at least 0 and below 1", cfg.RefreshJitter)
+	}
+	if cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 {
+		return fmt.Errorf("invalid server timeouts: values must not be negative")
+	}
+	if cfg.QueryTimeout <= 0 {
+		return fmt.Errorf("invalid query_timeout %s: must be greater than zero", cfg.QueryTimeout)
+	}
+	if cfg.ScrapeTimeout < 0 {
+		return fmt.Errorf("invalid scrape_timeout %s: must not be negative", cfg.ScrapeTimeout)
+	}
+	if cfg.QueryRetries < 0 {
+		return fmt.Errorf("invalid query_retries %d: must not be negative", cfg.QueryRetries)
+	}
+	if cfg.MaxConcurrentQueries < 1 {
+		return fmt.Errorf("invalid max_concurrent_queries %d: must be at least 1", cfg.MaxConcurrentQueries)
+	}
+	if cfg.TableStorageLimit < 1 {
+		return fmt.Errorf("invalid table_storage_limit %d: must be at least 1", cfg.TableStorageLimit)
+	}
+	switch cfg.UsageSchema {
+	case "account_usage", "organization_usage":
+	default:
+		return fmt.Errorf("invalid usage_schema %q: must be account_usage or organization_usage", cfg.UsageSchema)
+	}
+	for _, table := range cfg.ClusteringTables {
+		parts := strings.Split(table, ".")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return fmt.Errorf("invalid clustering_tables entry %q: must be DATABASE.SCHEMA.TABLE", table)
+		}
+	}
+	if cfg.MetricGroups["clustering_depth"] && len(cfg.ClusteringTables) == 0 {
+		return fmt.Errorf("metric group clustering_depth requires clustering_tables")
+	}
+	if cfg.Pool.MaxOpenConns < 1 {
+		return fmt.Errorf("invalid pool max_open_conns %d: must be at least 1", cfg.Pool.MaxOpenConns)
+	}
+	if cfg.Pool.MaxIdleConns < 0 || cfg.Pool.ConnMaxLifetime < 0 {
+		return fmt.Errorf("invalid pool settings: values must not be negative")
+	}
+	if err := cfg.Proxy.validate(); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(cfg.ListenPort, 10, 16); err != nil {
+		return fmt.Errorf("invalid listen_port %q", cfg.ListenPort)
+	}
+	if cfg.ListenAddress != "" {
+		if _, _, err := net.SplitHostPort(cfg.ListenAddress); err != nil {
+			return fmt.Errorf("invalid listen_address %q: %v", cfg.ListenAddress, err)
+		}
+	}
+	if !strings.HasPrefix(cfg.MetricsPath, "/") {
+		return fmt.Errorf("invalid metrics_path %q: must begin with /", cfg.MetricsPath)
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if (cfg.MetricsAuthUsername == "") != (cfg.MetricsAuthPassword == "") {
+		return fmt.Errorf("metrics_auth_username and metrics_auth_password must be set together")
+	}
+	for _, path := range []string{cfg.TLSCertFile, cfg.TLSKeyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("invalid TLS file: %v", err)
+		}
+	}
+
+	for name := range cfg.ExtraLabels {
+		if !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid extra label name %q", name)
+		}
+		if name == "account" && cfg.accountLabel() {
+			return fmt.Errorf("extra label %q clashes with the account label", name)
+		}
+	}
+
+	cfg.Connection.accountFromDSN()
+	for i := range cfg.Accounts {
+		cfg.Accounts[i].accountFromDSN()
+	}
+
+	seen := map[string]bool{}
+	for _, cc := range cfg.Accounts {
+		if seen[cc.Account] {
+			return fmt.Errorf("duplicate account %q", cc.Account)
+		}
+		seen[cc.Account] = true
+	}
+
+	known := map[string]bool{}
+	for _, name := range metricGroupNames() {
+		known[name] = true
+	}
+	for name := range cfg.MetricGroups {
+		if !known[name] {
+			return fmt.Errorf("unknown metric group %q", name)
+		}
+	}
+	for name, query := range cfg.Queries {
+		if !known[name] {
+			return fmt.Errorf("unknown metric group %q in queries", name)
+		}
+		if strings.TrimSpace(query) == "" {
+			return fmt.Errorf("empty query for metric group %q", name)
+		}
+		if boundGroups[name] || (filteredGroups[name] && len(cfg.WarehouseFilter) > 0) {
+			return fmt.Errorf("cannot replace the query of metric group %q: it takes bind parameters", name)
+		}
+	}
+
+	// Custom metrics are also reported as query groups under their own name,
+	// are registered next to the built-in metrics and carry the account and
+	// extra labels, so none of these may clash
+	names := map[string]bool{}
+	builtin := builtinMetricNames()
+	for _, metric := range cfg.CustomMetrics {
+		if err := metric.validate(); err != nil {
+			return err
+		}
+		if builtin[metric.Name] {
+			return fmt.Errorf("custom metric %q clashes with a built-in metric", metric.Name)
+		}
+		if known[metric.Name] || names[metric.Name] {
+			return fmt.Errorf("duplicate custom metric name %q", metric.Name)
+		}
+		names[metric.Name] = true
+		for _, label := range metric.Labels {
+			if _, ok := cfg.ExtraLabels[label]; ok || (label == "account" && cfg.accountLabel()) {
+				return fmt.Errorf("custom metric %s: label %q clashes with an exporter-wide label", metric.Name, label)
+			}
+		}
+	}
+
+	for name, ttl := range cfg.CacheTTLs {
+		if !known[name] && !names[name] {
+			return fmt.Errorf("unknown metric group %q in cache_ttls", name)
+		}
+		if ttl < 0 {
+			return fmt.Errorf("invalid cache_ttls %s %s: must not be negative", name, ttl)
+		}
+	}
+	return nil
+}
+
+// boundGroups are the metric groups whose queries always take bind
+// parameters, which a replacement from Queries would be run without.
+var boundGroups = map[string]bool{
+	"table_storage":    true,
+	"clustering_depth": true,
+}
+
+// filteredGroups are the metric groups whose queries bind the warehouses of
+// WarehouseFilter when it is set.
+var filteredGroups = map[string]bool{
+	"warehouse_credits": true,
+	"query_count":       true,
+}
+
+// accounts returns the connections of the accounts to export: Accounts when
+// set, otherwise the single Connection.
+func (cfg Config) accounts() []connectionConfig {
+	if len(cfg.Accounts) > 0 {
+		return cfg.Accounts
+	}
+	return []connectionConfig{cfg.Connection}
+}
+
+// accountLabel reports whether metrics are labeled with their account.
+func (cfg Config) accountLabel() bool {
+	return !cfg.DisableAccountLabel || len(cfg.Accounts) > 1
+}
+
+// metricLabels returns the constant labels added to every metric of the
+// given account: the extra labels plus, when enabled, the account itself.
+func (cfg Config) metricLabels(account string) map[string]string {
+	labels := map[string]string{}
+	for name, value := range cfg.ExtraLabels {
+		labels[name] = value
+	}
+	if cfg.accountLabel() {
+		labels["account"] = account
+	}
+	return labels
+}
+
+// listenAddress returns the address the HTTP server binds: ListenAddress when
+// set, otherwise ListenPort on all interfaces.
+func (cfg Config) listenAddress() string {
+	if cfg.ListenAddress != "" {
+		return cfg.ListenAddress
+	}
+	return ":" + cfg.ListenPort
+}
+
+// poolConfig holds the database/sql connection pool settings.
+type poolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// apply configures the connection pool of db.
+func (p poolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+}
+
+// proxyConfig holds the HTTP proxy that connections to Snowflake go through.
+// The proxy is unused when Host is empty.
+type proxyConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// validate checks that a proxy, when configured, has a host and a valid
+// port, and that its credentials are complete.
+func (p proxyConfig) validate() error {
+	if p.Host == "" {
+		if p.Port != 0 || p.User != "" || p.Password != "" {
+			return fmt.Errorf("proxy host is required when other proxy settings are set")
+		}
+		return nil
+	}
+	if strings.Contains(p.Host, "://") || strings.ContainsAny(p.Host, "/@") {
+		return fmt.Errorf("invalid proxy host %q: must be a hostname or IP address", p.Host)
+	}
+	if p.Port < 1 || p.Port > 65535 {
+		return fmt.Errorf("invalid proxy port %d: must be between 1 and 65535", p.Port)
+	}
+	if p.Password != "" && p.User == "" {
+		return fmt.Errorf("proxy user is required when a proxy password is set")
+	}
+	return nil
+}
+
+// url returns the proxy URL, or nil when no proxy is configured.
+func (p proxyConfig) url() *url.URL {
+	if p.Host == "" {
+		return nil
+	}
+	u := &url.URL{Scheme: "http", Host: net.JoinHostPort(p.Host, strconv.Itoa(p.Port))}
+	if p.User != "" {
+		u.User = url.UserPassword(p.User, p.Password)
+	}
+	return u
+}
+
+// apply routes outbound connections through the proxy. gosnowflake takes its
+// proxy from the standard HTTPS_PROXY and HTTP_PROXY environment variables,
+// read once on first use, so apply must run before connecting. It leaves the
+// environment untouched when no proxy is configured.
+func (p proxyConfig) apply() error {
+	u := p.url()
+	if u == nil {
+		return nil
+	}
+	for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY"} {
+		if err := os.Setenv(name, u.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connectionConfig holds the Snowflake connection settings.
+type connectionConfig struct {
+	// DSN, when set, is a complete gosnowflake DSN used verbatim instead of
+	// one built from the settings below, for connection parameters they do
+	// not cover. Only QueryWarehouse still applies on top of it.
+	DSN string `yaml:"dsn"`
+
+	Account   string `yaml:"account"`
+	User      string `yaml:"user"`
+	Password  string `yaml:"password"`
+	Database  string `yaml:"database"`
+	Schema    string `yaml:"schema"`
+	Warehouse string `yaml:"warehouse"`
+
+	// Host overrides the host derived from Account, such as
+	// myaccount.eu-central-1.privatelink.snowflakecomputing.com for AWS
+	// PrivateLink. Account still identifies the account.
+	Host string `yaml:"host"`
+
+	// QueryWarehouse, when set, is the warehouse the exporter's queries run
+	// on, selected with USE WAREHOUSE on each connection. Warehouse stays
+	// the connection's default.
+	QueryWarehouse string `yaml:"query_warehouse"`
+
+	// Role is the role the session uses instead of the user's default. It
+	// needs access to the SNOWFLAKE.ACCOUNT_USAGE views.
+	Role string `yaml:"role"`
+
+	// Timezone, such as Europe/Berlin, is the session time zone. Day-based
+	// metrics such as storage bytes use current_date(), which otherwise
+	// follows the account's time zone.
+	Timezone string `yaml:"timezone"`
+
+	// QueryTag is set as the session's QUERY_TAG so the exporter's own
+	// queries can be found, or filtered out, in query_history. It defaults
+	// to snowflake-exporter.
+	QueryTag string `yaml:"query_tag"`
+
+	// PrivateKeyPath points at a PEM encoded private key. When set, key-pair
+	// (JWT) authentication is used instead of the password.
+	PrivateKeyPath       string `yaml:"private_key_path"`
+	PrivateKeyPassphrase string `yaml:"private_key_passphrase"`
+
+	// Authenticator selects the authentication method: empty or snowflake
+	// for password or key-pair authentication, or oauth to authenticate
+	// with an OAuth access token. The token is given inline or read from
+	// OAuthTokenPath, which takes precedence. externalbrowser signs User in
+	// through SSO in a web browser; it is meant for running the exporter
+	// locally and does not work on a server, where nobody can complete the
+	// login.
+	Authenticator  string `yaml:"authenticator"`
+	OAuthToken     string `yaml:"oauth_token"`
+	OAuthTokenPath string `yaml:"oauth_token_path"`
+
+	// InsecureMode sets the gosnowflake InsecureMode flag, which skips the
+	// OCSP certificate revocation checks. It is meant for lab setups behind
+	// proxies with self-signed certificates, never for production, as a
+	// revoked certificate is then accepted.
+	InsecureMode bool `yaml:"insecure_mode"`
+
+	// OCSPFailOpen selects what happens when the OCSP responder cannot be
+	// reached to check the certificate's revocation status. Fail-open, the
+	// driver's default when unset, connects anyway, so a revoked certificate
+	// may go unnoticed while the responder is blocked. Fail-closed, false,
+	// refuses the connection, which is safer but makes every connection in a
+	// network without access to the responder fail.
+	OCSPFailOpen *bool `yaml:"ocsp_fail_open"`
+}
+
+// applyEnv overrides the connection settings with any SNOWFLAKE_*
+// environment variables that are set. Secrets may also be read from the file
+// named by the variable with a _FILE suffix.
+func (cc *connectionConfig) applyEnv() error {
+	setFromEnv(&cc.Account, "SNOWFLAKE_ACCOUNT")
+	setFromEnv(&cc.Host, "SNOWFLAKE_HOST")
+	setFromEnv(&cc.User, "SNOWFLAKE_USERNAME")
+	setFromEnv(&cc.Database, "SNOWFLAKE_DATABASE")
+	setFromEnv(&cc.Schema, "SNOWFLAKE_SCHEMA")
+	setFromEnv(&cc.Warehouse, "SNOWFLAKE_WAREHOUSE")
+	setFromEnv(&cc.QueryWarehouse, "SNOWFLAKE_QUERY_WAREHOUSE")
+	setFromEnv(&cc.Role, "SNOWFLAKE_ROLE")
+	setFromEnv(&cc.Timezone, "SNOWFLAKE_TIMEZONE")
+	setFromEnv(&cc.QueryTag, "SNOWFLAKE_QUERY_TAG")
+	setFromEnv(&cc.PrivateKeyPath, "SNOWFLAKE_PRIVATE_KEY_PATH")
+	setFromEnv(&cc.Authenticator, "SNOWFLAKE_AUTHENTICATOR")
+	setFromEnv(&cc.OAuthTokenPath, "SNOWFLAKE_OAUTH_TOKEN_PATH")
+
+	for _, secret := range []struct {
+		value *string
+		name  string
+	}{
+		{&cc.Password, "SNOWFLAKE_PASSWORD"},
+		{&cc.PrivateKeyPassphrase, "SNOWFLAKE_PRIVATE_KEY_PASSPHRASE"},
+		{&cc.OAuthToken, "SNOWFLAKE_OAUTH_TOKEN"},
+		{&cc.DSN, "SNOWFLAKE_DSN"},
+	} {
+		if err := setSecretFromEnv(secret.value, secret.name); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if cc.InsecureMode, err = boolFromEnv("SNOWFLAKE_INSECURE_MODE", cc.InsecureMode); err != nil {
+		return fmt.Errorf("invalid SNOWFLAKE_INSECURE_MODE: %v", err)
+	}
+	if os.Getenv("SNOWFLAKE_OCSP_FAIL_OPEN") != "" {
+		failOpen, err := boolFromEnv("SNOWFLAKE_OCSP_FAIL_OPEN", true)
+		if err != nil {
+			return fmt.Errorf("invalid SNOWFLAKE_OCSP_FAIL_OPEN: %v", err)
+		}
+		cc.OCSPFailOpen = &failOpen
+	}
+	return nil
+}
+
+// Authenticator values selecting OAuth and external browser SSO.
+const (
+	authenticatorOAuth           = "oauth"
+	authenticatorExternalBrowser = "externalbrowser"
+)
+
+// validateConfig checks that every required connection setting is present,
+// reporting all missing settings at once by their environment variable.
+func validateConfig(cc connectionConfig) error {
+	if cc.DSN != "" {
+		if _, err := gosnowflake.ParseDSN(cc.DSN); err != nil {
+			return fmt.Errorf("invalid SNOWFLAKE_DSN: %v", err)
+		}
+		return validateQueryWarehouse(cc)
+	}
+
+	var missing []string
+	if cc.Account == "" {
+		missing = append(missing, "SNOWFLAKE_ACCOUNT")
+	}
+
+	// OAuth identifies the user by the token, so no username is needed
+	switch strings.ToLower(cc.Authenticator) {
+	case "", "snowflake":
+		if cc.User == "" {
+			missing = append(missing, "SNOWFLAKE_USERNAME")
+		}
+		if cc.Password == "" && cc.PrivateKeyPath == "" {
+			missing = append(missing, "SNOWFLAKE_PASSWORD (or SNOWFLAKE_PRIVATE_KEY_PATH)")
+		}
+	case authenticatorOAuth:
+		if cc.OAuthToken == "" && cc.OAuthTokenPath == "" {
+			missing = append(missing, "SNOWFLAKE_OAUTH_TOKEN (or SNOWFLAKE_OAUTH_TOKEN_PATH)")
+		}
+	case authenticatorExternalBrowser:
+		// The browser login must match the configured user
+		if cc.User == "" {
+			missing = append(missing, "SNOWFLAKE_USERNAME")
+		}
+	default:
+		return fmt.Errorf("unsupported SNOWFLAKE_AUTHENTICATOR %q", cc.Authenticator)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required configuration: %s", strings.Join(missing, ", "))
+	}
+	if cc.Host != "" && !hostRE.MatchString(cc.Host) {
+		return fmt.Errorf("invalid SNOWFLAKE_HOST %q: must be a host name without scheme, port or path", cc.Host)
+	}
+	return validateQueryWarehouse(cc)
+}
+
+// validateQueryWarehouse checks that QueryWarehouse, which is spliced into a
+// USE WAREHOUSE statement, is a plain identifier.
+func validateQueryWarehouse(cc connectionConfig) error {
+	if cc.QueryWarehouse != "" && !identifierRE.MatchString(cc.QueryWarehouse) {
+		return fmt.Errorf("invalid SNOWFLAKE_QUERY_WAREHOUSE %q: must be an unquoted identifier", cc.QueryWarehouse)
+	}
+	return nil
+}
+
+// accountFromDSN sets Account from DSN when only the DSN names the account,
+// so its metrics are still labeled. An invalid DSN is left for
+// validateConfig to report.
+func (cc *connectionConfig) accountFromDSN() {
+	if cc.DSN == "" || cc.Account != "" {
+		return
+	}
+	if parsed, err := gosnowflake.ParseDSN(cc.DSN); err == nil {
+		cc.Account = parsed.Account
+	}
+}
+
+// setFromEnv replaces *value with the named environment variable if it is
+// set and non-empty.
+func setFromEnv(value *string, name string) {
+	if v := os.Getenv(name); v != "" {
+		*value = v
+	}
+}
+
+// setSecretFromEnv is setFromEnv for secrets, which may also be read from a
+// file as described by readSecretEnv.
+func setSecretFromEnv(value *string, name string) error {
+	v, err := readSecretEnv(name)
+	if err != nil {
+		return err
+	}
+	if v != "" {
+		*value = v
+	}
+	return nil
+}
+
+// readSecretEnv returns the secret in the named environment variable. When
+// name_FILE is set, the secret is instead read from the file it names, which
+// takes precedence, and trailing newlines are trimmed.
+func readSecretEnv(name string) (string, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return os.Getenv(name), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s_FILE: %v", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// snowflakeConfig converts the connection settings into a gosnowflake
+// configuration. Password authentication is used unless OAuth or external
+// browser SSO is selected or a private key path is configured.
+func (cc connectionConfig) snowflakeConfig() (*gosnowflake.Config, error) {
+	cfg := &gosnowflake.Config{
+		Account:   cc.Account,
+		User:      cc.User,
+		Database:  cc.Database,
+		Schema:    cc.Schema,
+		Warehouse: cc.Warehouse,
+		Role:      cc.Role,
+		Host:      cc.Host,
+
+		InsecureMode: cc.InsecureMode,
+	}
+	if cc.OCSPFailOpen != nil {
+		cfg.OCSPFailOpen = gosnowflake.OCSPFailOpenFalse
+		if *cc.OCSPFailOpen {
+			cfg.OCSPFailOpen = gosnowflake.OCSPFailOpenTrue
+		}
+	}
+
+	// Session parameters in the DSN apply to every pooled connection, unlike
+	// an ALTER SESSION run on just one of them
+	queryTag := cc.QueryTag
+	if queryTag == "" {
+		queryTag = defaultQueryTag
+	}
+	cfg.Params = map[string]*string{"query_tag": &queryTag}
+	if cc.Timezone != "" {
+		timezone := cc.Timezone
+		cfg.Params["timezone"] = &timezone
+	}
+
+	if strings.EqualFold(cc.Authenticator, authenticatorExternalBrowser) {
+		cfg.Authenticator = gosnowflake.AuthTypeExternalBrowser
+		return cfg, nil
+	}
+
+	if strings.EqualFold(cc.Authenticator, authenticatorOAuth) {
+		token, err := cc.oauthToken()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Authenticator = gosnowflake.AuthTypeOAuth
+		cfg.Token = token
+		return cfg, nil
+	}
+
+	if cc.PrivateKeyPath == "" {
+		cfg.Password = cc.Password
+		return cfg, nil
+	}
+
+	privateKey, err := loadPrivateKey(cc.PrivateKeyPath, cc.PrivateKeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Authenticator = gosnowflake.AuthTypeJwt
+	cfg.PrivateKey = privateKey
+	return cfg, nil
+}
+
+// oauthToken returns the OAuth access token, reading it from OAuthTokenPath
+// when set.
+func (cc connectionConfig) oauthToken() (string, error) {
+	if cc.OAuthTokenPath == "" {
+		return cc.OAuthToken, nil
+	}
+	data, err := os.ReadFile(cc.OAuthTokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read OAuth token: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("OAuth token file %s is empty", cc.OAuthTokenPath)
+	}
+	return token, nil
+}
+
+// buildDSN assembles a Snowflake DSN from the connection settings. The DSN is
+// produced by gosnowflake so that reserved characters in credentials are
+// escaped correctly. A configured passthrough DSN is returned as is.
+func buildDSN(cc connectionConfig) (string, error) {
+	if cc.DSN != "" {
+		return cc.DSN, nil
+	}
+	cfg, err := cc.snowflakeConfig()
+	if err != nil {
+		return "", err
+	}
+	dsn, err := gosnowflake.DSN(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to build Snowflake DSN: %v", err)
+	}
+	return dsn, nil
+}
+
+// loadPrivateKey reads an RSA private key from a PEM file. Both PKCS#1 and
+// PKCS#8 keys are accepted; encrypted PKCS#8 keys require a passphrase.
+func loadPrivateKey(path, passphrase string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key %s: no PEM data found", path)
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %v", err)
+		}
+		return privateKey, nil
+	}
+
+	var password []byte
+	if passphrase != "" {
+		password = []byte(passphrase)
+	}
+	privateKey, err := pkcs8.ParsePKCS8PrivateKeyRSA(block.Bytes, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	return privateKey, nil
+}
+
+// parseLookback parses a lookback window such as "6h" or "7d". Go duration
+// syntax is accepted, plus a "d" suffix for whole days. An empty string
+// yields the default window.
+func parseLookback(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultLookback, nil
+	}
+
+	var lookback time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lookback %q: %v", s, err)
+		}
+		lookback = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lookback %q: %v", s, err)
+		}
+		lookback = d
+	}
+
+	if lookback < time.Second {
+		return 0, fmt.Errorf("invalid lookback %q: must be at least 1s", s)
+	}
+	return lookback, nil
+}
+
+// durationFromEnv parses the named environment variable as a Go duration,
+// returning def when it is unset. Negative durations are rejected.
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %v", value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q: must not be negative", value)
+	}
+	return d, nil
+}
+
+// hostRE matches DNS host names.
+var hostRE = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$`)
+
+// labelNameRE matches valid Prometheus label names.
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseLabels parses a comma-separated list of name=value pairs.
+func parseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range splitList(s) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: expected name=value", pair)
+		}
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// boolFromEnv parses the named environment variable as a boolean, returning
+// def when it is unset.
+func boolFromEnv(name string, def bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q", value)
+	}
+	return b, nil
+}
+
+// splitList splits a comma-separated list, trimming whitespace and dropping
+// empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// intFromEnv parses the named environment variable as an integer, returning
+// def when it is unset.
+func intFromEnv(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", value)
+	}
+	return n, nil
+}
+
+// floatFromEnv parses the named environment variable as a floating point
+// number, returning def when it is unset.
+func floatFromEnv(name string, def float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return f, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// contextConnector stops waiting for a connection once ctx is done. The
+// Snowflake driver logs in under a background context whatever context it is
+// given, so without it a scrape deadline cannot interrupt a slow login. A
+// connection that still arrives after ctx is done is closed.
+type contextConnector struct {
+	driver.Connector
+}
+
+func (c contextConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	type result struct {
+		conn driver.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := c.Connector.Connect(ctx)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// sessionConnector runs setup statements on every new connection before the
+// pool hands it out, so they apply to all of the exporter's queries however
+// the pool spreads them over connections.
+type sessionConnector struct {
+	driver.Connector
+	statements []string
+}
+
+func (c sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("driver does not support session setup statements")
+	}
+	for _, statement := range c.statements {
+		if _, err := execer.ExecContext(ctx, statement, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to run %q: %v", statement, err)
+		}
+	}
+	return conn, nil
+}
+
+// openDB opens a Snowflake connection pool for dsn whose connections first
+// run the given session statements. Connections are opened under the context
+// of the query that needs them, so a cancelled scrape does not wait for a
+// login in progress.
+func openDB(dsn string, session []string) (*sql.DB, error) {
+	cfg, err := gosnowflake.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Snowflake DSN: %v", err)
+	}
+	var connector driver.Connector = contextConnector{gosnowflake.NewConnector(gosnowflake.SnowflakeDriver{}, *cfg)}
+	if len(session) > 0 {
+		connector = sessionConnector{connector, session}
+	}
+	return sql.OpenDB(connector), nil
+}
+
+// identifierRE matches unquoted Snowflake identifiers, which are safe to
+// splice into statements that do not accept bind parameters.
+var identifierRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+
+// sessionStatements returns the statements that set up each connection of an
+// account.
+func (cc connectionConfig) sessionStatements() []string {
+	var statements []string
+	if cc.QueryWarehouse != "" {
+		statements = append(statements, "USE WAREHOUSE "+cc.QueryWarehouse)
+	}
+	return statements
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// dsnConnector opens connections to a fixed DSN of a driver without a
+// connector of its own, like sql.Open does.
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sessionDB opens a pool over the sqlmock connection named dsn whose
+// connections run the given session statements.
+func sessionDB(t *testing.T, dsn string, statements ...string) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	mockDB, mock, err := sqlmock.NewWithDSN(dsn)
+	assert.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	db := sql.OpenDB(sessionConnector{dsnConnector{dsn, mockDB.Driver()}, statements})
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func TestSessionConnector_QueryWarehouse(t *testing.T) {
+	cc := connectionConfig{Warehouse: "COMPUTE_WH", QueryWarehouse: "MONITORING_WH"}
+	db, mock := sessionDB(t, "query_warehouse", cc.sessionStatements()...)
+
+	// The warehouse is selected before the connection runs any query
+	mock.ExpectExec("^USE WAREHOUSE MONITORING_WH$").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	_, err := collector.scrape(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSessionConnector_SetupFailure(t *testing.T) {
+	db, mock := sessionDB(t, "query_warehouse_missing", "USE WAREHOUSE MISSING_WH")
+	mock.ExpectExec("USE WAREHOUSE MISSING_WH").WillReturnError(fmt.Errorf("warehouse does not exist"))
+
+	assert.ErrorContains(t, db.PingContext(context.Background()), "warehouse does not exist")
+}
+
+func TestOpenDB_ConnectHonorsContext(t *testing.T) {
+	// The listener accepts the login request but never answers it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	db, err := openDB(fmt.Sprintf("user:password@127.0.0.1:%d/?account=acct&protocol=http&loginTimeout=1", port), []string{"USE WAREHOUSE MONITORING_WH"})
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, db.PingContext(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestOpenDB_InvalidDSN(t *testing.T) {
+	_, err := openDB("user:password@host:port/?account", nil)
+	assert.ErrorContains(t, err, "failed to parse Snowflake DSN")
+}
+
+func TestConnectionConfig_SessionStatements(t *testing.T) {
+	assert.Empty(t, connectionConfig{Warehouse: "COMPUTE_WH"}.sessionStatements())
+	assert.Equal(t, []string{"USE WAREHOUSE MONITORING_WH"},
+		connectionConfig{Warehouse: "COMPUTE_WH", QueryWarehouse: "MONITORING_WH"}.sessionStatements())
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CustomMetric is a user-defined gauge backed by its own SQL query. Each row
+// the query returns becomes one series, valued by ValueColumn and labeled by
+// Labels, which name both the label and the column it is read from. Column
+// names are matched case-insensitively, as Snowflake upper-cases unquoted
+// identifiers.
+type CustomMetric struct {
+	Name        string   `yaml:"name"`
+	Help        string   `yaml:"help"`
+	Query       string   `yaml:"query"`
+	ValueColumn string   `yaml:"value_column"`
+	Labels      []string `yaml:"labels"`
+}
+
+// metricNameRE matches valid Prometheus metric names.
+var metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// exporterMetrics are the metrics the exporter reports about itself rather
+// than from a metric group.
+var exporterMetrics = []string{
+	"snowflake_lookback_window_seconds",
+	"snowflake_up",
+	"snowflake_scrape_duration_seconds",
+	"snowflake_scrape_query_duration_seconds",
+	"snowflake_last_scrape_success_timestamp_seconds",
+	"snowflake_permission_error",
+	"snowflake_db_open_connections",
+	"snowflake_db_in_use_connections",
+	"snowflake_scrape_errors_total",
+	"snowflake_reconnects_total",
+	"snowflake_exporter_build_info",
+}
+
+// builtinMetricNames returns the names of all metrics the exporter emits
+// itself, whether or not their groups are enabled.
+func builtinMetricNames() map[string]bool {
+	names := map[string]bool{}
+	for _, group := range newSnowflakeMetricsCollector(nil).metricGroups() {
+		for _, name := range group.metrics {
+			names[name] = true
+		}
+	}
+	for _, name := range exporterMetrics {
+		names[name] = true
+	}
+	return names
+}
+
+// validate checks that the metric is complete and its name and labels are
+// valid Prometheus names that do not collide with each other.
+func (m CustomMetric) validate() error {
+	if !metricNameRE.MatchString(m.Name) {
+		return fmt.Errorf("invalid custom metric name %q", m.Name)
+	}
+	if m.Help == "" {
+		return fmt.Errorf("custom metric %s: help is required", m.Name)
+	}
+	if strings.TrimSpace(m.Query) == "" {
+		return fmt.Errorf("custom metric %s: query is required", m.Name)
+	}
+	if m.ValueColumn == "" {
+		return fmt.Errorf("custom metric %s: value_column is required", m.Name)
+	}
+
+	columns := map[string]bool{strings.ToLower(m.ValueColumn): true}
+	for _, label := range m.Labels {
+		if !labelNameRE.MatchString(label) || strings.HasPrefix(label, "__") {
+			return fmt.Errorf("custom metric %s: invalid label name %q", m.Name, label)
+		}
+		if columns[strings.ToLower(label)] {
+			return fmt.Errorf("custom metric %s: column %q is used more than once", m.Name, label)
+		}
+		columns[strings.ToLower(label)] = true
+	}
+	return nil
+}
+
+// customMetric is a CustomMetric ready to be collected.
+type customMetric struct {
+	CustomMetric
+	desc *prometheus.Desc
+}
+
+// loadCustomMetrics builds the descriptors of the configured custom metrics.
+func loadCustomMetrics(defs []CustomMetric) []customMetric {
+	metrics := make([]customMetric, 0, len(defs))
+	for _, def := range defs {
+		metrics = append(metrics, customMetric{
+			CustomMetric: def,
+			desc:         prometheus.NewDesc(def.Name, def.Help, def.Labels, nil),
+		})
+	}
+	return metrics
+}
+
+// customGroups returns a metric group per custom metric, named after the
+// metric.
+func (c *SnowflakeMetricsCollector) customGroups() []metricGroup {
+	groups := make([]metricGroup, 0, len(c.customMetrics))
+	for _, metric := range c.customMetrics {
+		groups = append(groups, metricGroup{
+			name: metric.Name,
+			collect: func(ctx context.Context, ch chan<- prometheus.Metric) error {
+				return c.collectCustomMetric(ctx, metric, ch)
+			},
+			descs:   []*prometheus.Desc{metric.desc},
+			metrics: []string{metric.Name},
+			sources: []string{"custom query"},
+		})
+	}
+	return groups
+}
+
+// collectCustomMetric runs a custom metric's query and emits a series per
+// row. A query missing the value or a label column fails the group.
+func (c *SnowflakeMetricsCollector) collectCustomMetric(ctx context.Context, metric customMetric, ch chan<- prometheus.Metric) error {
+	rows, err := c.query(ctx, metric.Name, metric.Query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	index := map[string]int{}
+	for i, column := range columns {
+		index[strings.ToLower(column)] = i
+	}
+	valueIndex, ok := index[strings.ToLower(metric.ValueColumn)]
+	if !ok {
+		return fmt.Errorf("custom metric %s: query returned no %q column", metric.Name, metric.ValueColumn)
+	}
+	labelIndexes := make([]int, len(metric.Labels))
+	for i, label := range metric.Labels {
+		if labelIndexes[i], ok = index[strings.ToLower(label)]; !ok {
+			return fmt.Errorf("custom metric %s: query returned no %q column", metric.Name, label)
+		}
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			c.logger.Error("Error scanning custom metric", "metric", metric.Name, "err", err)
+			c.scrapeErrors.WithLabelValues(metric.Name).Inc()
+			continue
+		}
+
+		if !values[valueIndex].Valid {
+			c.logger.Debug("Skipping row with NULL value", "query", metric.Name)
+			continue
+		}
+		value, err := strconv.ParseFloat(values[valueIndex].String, 64)
+		if err != nil {
+			c.logger.Error("Error parsing custom metric value", "metric", metric.Name, "err", err)
+			c.scrapeErrors.WithLabelValues(metric.Name).Inc()
+			continue
+		}
+
+		labelValues := make([]string, len(labelIndexes))
+		for i, idx := range labelIndexes {
+			labelValues[i] = values[idx].String
+		}
+		ch <- prometheus.MustNewConstMetric(metric.desc, prometheus.GaugeValue, value, labelValues...)
+	}
+	return rows.Err()
+}
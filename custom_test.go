@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// warehouseQueueMetric is a multi-label custom metric used by the tests.
+var warehouseQueueMetric = CustomMetric{
+	Name:        "snowflake_custom_queued_queries",
+	Help:        "Queued queries by warehouse and user",
+	Query:       "SELECT warehouse_name, user_name, COUNT(*) AS queued FROM monitoring.public.queued_queries GROUP BY 1, 2",
+	ValueColumn: "queued",
+	Labels:      []string{"warehouse_name", "user_name"},
+}
+
+func TestSnowflakeMetricsCollector_CustomMetric(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Columns come back upper-cased and in a different order than the
+	// labels; rows with a NULL value are skipped
+	mock.ExpectQuery("SELECT warehouse_name, user_name, COUNT\\(\\*\\) AS queued FROM monitoring.public.queued_queries").
+		WillReturnRows(sqlmock.NewRows([]string{"QUEUED", "USER_NAME", "WAREHOUSE_NAME"}).
+			AddRow(3, "ETL", "COMPUTE_WH").
+			AddRow(1.5, "ANALYST", "REPORTING_WH").
+			AddRow(nil, "ADMIN", "COMPUTE_WH"))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("")
+	collector.customMetrics = loadCustomMetrics([]CustomMetric{warehouseQueueMetric})
+
+	expected := `
+		# HELP snowflake_custom_queued_queries Queued queries by warehouse and user
+		# TYPE snowflake_custom_queued_queries gauge
+		snowflake_custom_queued_queries{user_name="ANALYST",warehouse_name="REPORTING_WH"} 1.5
+		snowflake_custom_queued_queries{user_name="ETL",warehouse_name="COMPUTE_WH"} 3
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 1
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_custom_queued_queries", "snowflake_up")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_CustomMetricMissingColumn(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("FROM monitoring.public.queued_queries").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "queued"}).
+			AddRow("COMPUTE_WH", 3))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("")
+	collector.customMetrics = loadCustomMetrics([]CustomMetric{warehouseQueueMetric})
+
+	expected := `
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 0
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_custom_queued_queries", "snowflake_up")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("snowflake_custom_queued_queries")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCustomMetric_Validate(t *testing.T) {
+	assert.NoError(t, warehouseQueueMetric.validate())
+
+	tests := map[string]func(m *CustomMetric){
+		"bad name":         func(m *CustomMetric) { m.Name = "queued-queries" },
+		"no help":          func(m *CustomMetric) { m.Help = "" },
+		"no query":         func(m *CustomMetric) { m.Query = " " },
+		"no value column":  func(m *CustomMetric) { m.ValueColumn = "" },
+		"bad label":        func(m *CustomMetric) { m.Labels = []string{"warehouse-name"} },
+		"reserved label":   func(m *CustomMetric) { m.Labels = []string{"__name"} },
+		"duplicate label":  func(m *CustomMetric) { m.Labels = []string{"user_name", "USER_NAME"} },
+		"value as a label": func(m *CustomMetric) { m.Labels = []string{"queued"} },
+	}
+	for name, mutate := range tests {
+		metric := warehouseQueueMetric
+		metric.Labels = append([]string(nil), metric.Labels...)
+		mutate(&metric)
+		assert.Error(t, metric.validate(), name)
+	}
+}
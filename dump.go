@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// runDump scrapes every configured account once and writes the metrics in
+// the Prometheus text format to path, or to stdout when path is empty,
+// exactly as the metrics endpoint would serve them.
+func runDump(cfg Config, path string) error {
+	registry := newRegistry(cfg)
+	if err := labeledRegisterer(registry, cfg.ExtraLabels).Register(newBuildInfo()); err != nil {
+		return fmt.Errorf("failed to register build info: %v", err)
+	}
+	for _, cc := range cfg.accounts() {
+		collector, err := newAccountCollector(cfg, cc)
+		if err != nil {
+			return fmt.Errorf("account %s: %v", cc.Account, err)
+		}
+		defer collector.Close()
+		if err := labeledRegisterer(registry, cfg.metricLabels(cc.Account)).Register(collector); err != nil {
+			return fmt.Errorf("failed to register collector for account %s: %v", cc.Account, err)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create dump file: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	return writeMetrics(w, registry)
+}
+
+// writeMetrics gathers the metrics of g and writes them to w in the
+// Prometheus text format.
+func writeMetrics(w io.Writer, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			return fmt.Errorf("failed to write metrics: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	expectSuccessfulScrape(mock)
+
+	collector := newSnowflakeMetricsCollector(db)
+	registry := prometheus.NewRegistry()
+	assert.NoError(t, labeledRegisterer(registry, prometheus.Labels{"account": "myaccount"}).Register(collector))
+
+	var out bytes.Buffer
+	assert.NoError(t, writeMetrics(&out, registry))
+	assert.Contains(t, out.String(), "# TYPE snowflake_warehouse_credits_used gauge")
+	assert.Contains(t, out.String(), `snowflake_warehouse_credits_used{account="myaccount",size="",warehouse_name="COMPUTE_WH"} 10.5`)
+	assert.Contains(t, out.String(), `snowflake_up{account="myaccount"} 1`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunDump_ConnectionFailure(t *testing.T) {
+	cfg := Config{
+		Connection: connectionConfig{
+			Account:        "myaccount",
+			User:           "exporter",
+			PrivateKeyPath: filepath.Join(t.TempDir(), "missing.p8"),
+		},
+	}
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	assert.Error(t, runDump(cfg, path))
+
+	// Nothing is written when no account could be set up
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// listMetrics writes every built-in metric group to w with the metrics it
+// emits and the views it reads, with the usage views read from usageSchema,
+// so the grants needed and the names to pass to metric_groups can be looked
+// up without connecting to Snowflake.
+func listMetrics(w io.Writer, usageSchema string) error {
+	collector := newSnowflakeMetricsCollector(nil)
+	collector.usageSchema = usageSchema
+	for _, group := range collector.metricGroups() {
+		name := group.name
+		if optInGroups[name] {
+			name += " (opt-in)"
+		}
+		if _, err := fmt.Fprintf(w, "%s\n  metrics: %s\n  reads:   %s\n",
+			name, strings.Join(group.metrics, ", "), strings.Join(group.sources, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
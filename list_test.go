@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListMetrics(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, listMetrics(&out, defaultUsageSchema))
+
+	// Every group is listed with its metrics and views
+	for _, name := range metricGroupNames() {
+		assert.Contains(t, out.String(), name)
+	}
+	assert.Contains(t, out.String(), "warehouse_credits\n"+
+		"  metrics: snowflake_warehouse_credits_used, snowflake_warehouse_credits_compute, snowflake_warehouse_credits_cloud_services\n"+
+		"  reads:   account_usage.warehouse_metering_history, account_usage.query_history\n")
+	assert.Contains(t, out.String(), "table_storage (opt-in)\n")
+	assert.Contains(t, out.String(), "  reads:   SHOW WAREHOUSES\n")
+}
+
+func TestListMetrics_OrganizationUsage(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, listMetrics(&out, "organization_usage"))
+
+	// The usage views follow the schema, while views that only exist in
+	// account_usage are listed unchanged
+	assert.Contains(t, out.String(), "data_transfer\n"+
+		"  metrics: snowflake_data_transfer_bytes_total\n"+
+		"  reads:   organization_usage.data_transfer_history\n")
+	assert.Contains(t, out.String(), "  reads:   organization_usage.metering_daily_history\n")
+	assert.NotContains(t, out.String(), "account_usage.stage_storage_usage_history")
+	assert.Contains(t, out.String(), "  reads:   account_usage.login_history\n")
+}
+
+func TestMetricGroups_MetricNames(t *testing.T) {
+	// Every group names each of its descriptors, in order
+	for _, group := range newSnowflakeMetricsCollector(nil).metricGroups() {
+		if assert.Len(t, group.metrics, len(group.descs), group.name) {
+			for i, desc := range group.descs {
+				assert.Contains(t, desc.String(), fmt.Sprintf("fqName: %q", group.metrics[i]), group.name)
+			}
+		}
+	}
+}
+
+func TestExporterMetrics(t *testing.T) {
+	// With every group disabled, the exporter's own descriptors remain, and
+	// each of them is listed
+	collector := newSnowflakeMetricsCollector(nil)
+	collector.enabledGroups = map[string]bool{}
+	for _, name := range metricGroupNames() {
+		collector.enabledGroups[name] = false
+	}
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		collector.Describe(descs)
+		newBuildInfo().Describe(descs)
+		close(descs)
+	}()
+	count := 0
+	for desc := range descs {
+		if count < len(exporterMetrics) {
+			assert.Contains(t, desc.String(), fmt.Sprintf("fqName: %q", exporterMetrics[count]))
+		}
+		count++
+	}
+	assert.Equal(t, len(exporterMetrics), count)
+}
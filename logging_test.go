@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogger_Levels(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "text")
+	assert.NoError(t, err)
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "level=WARN msg=shown")
+
+	// Debug records are dropped at the default level
+	buf.Reset()
+	logger, err = newLogger(&buf, "", "")
+	assert.NoError(t, err)
+	logger.Debug("hidden")
+	logger.Info("shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "msg=shown")
+}
+
+func TestNewLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "DEBUG", "json")
+	assert.NoError(t, err)
+
+	logger.Debug("refreshing", "query", "storage_bytes")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "DEBUG", record["level"])
+	assert.Equal(t, "refreshing", record["msg"])
+	assert.Equal(t, "storage_bytes", record["query"])
+}
+
+func TestNewLogger_Invalid(t *testing.T) {
+	_, err := newLogger(&bytes.Buffer{}, "verbose", "text")
+	assert.Error(t, err)
+
+	_, err = newLogger(&bytes.Buffer{}, "info", "xml")
+	assert.Error(t, err)
+}
+
+// logRecords decodes JSON log output into one map per record.
+func logRecords(t *testing.T, output string) []map[string]interface{} {
+	t.Helper()
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogFile_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exporter.log")
+	file, err := openLogFile(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	logger, err := newLogger(file, "info", "text")
+	assert.NoError(t, err)
+	logger.Info("before rotation")
+
+	// Log lines land in the file
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "msg=\"before rotation\"")
+
+	// After the file is moved aside, reopening starts a new one at the path
+	rotated := path + ".1"
+	assert.NoError(t, os.Rename(path, rotated))
+	assert.NoError(t, file.Reopen())
+	logger.Info("after rotation")
+
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "msg=\"after rotation\"")
+	assert.NotContains(t, string(content), "before rotation")
+
+	content, err = os.ReadFile(rotated)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "after rotation")
+}
+
+func TestOpenLogFile_Invalid(t *testing.T) {
+	_, err := openLogFile(filepath.Join(t.TempDir(), "missing", "exporter.log"))
+	assert.Error(t, err)
+}
@@ -1,41 +1,247 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/snowflakedb/gosnowflake"
 )
 
 type SnowflakeMetricsCollector struct {
-	db *sql.DB
+	// db is the current connection. It is replaced by reconnect, so it is
+	// read through conn and guarded by dbMu.
+	db   *sql.DB
+	dbMu sync.RWMutex
+
+	// open creates a fresh connection for reconnect. Collectors built
+	// around an existing handle have none and never reconnect. reconnectMu
+	// serializes reconnects without holding up readers of db.
+	open        func() (*sql.DB, error)
+	reconnectMu sync.Mutex
+
+	// lookback is how far back the history queries look
+	lookback time.Duration
+
+	// cacheTTL is how long scraped metrics are served before Snowflake is
+	// queried again. Zero disables caching.
+	cacheTTL   time.Duration
+	cached     []prometheus.Metric
+	lastScrape time.Time
+	// groupTTLs override cacheTTL for single metric groups, whose account
+	// usage views lag by different amounts. groupCache holds the latest
+	// successful result of each group, and expires is when the first of
+	// them goes stale and Collect has to scrape again.
+	groupTTLs  map[string]time.Duration
+	groupCache map[string]groupSnapshot
+	expires    time.Time
+	// lastSuccess is when the last fully successful scrape finished. Unlike
+	// lastScrape it is kept across failed scrapes.
+	lastSuccess time.Time
+	now         func() time.Time
+
+	// refreshInterval, when set, switches the collector to background
+	// refreshes: runRefresher scrapes on this interval and Collect only
+	// serves the latest snapshot. Zero scrapes on demand from Collect.
+	refreshInterval time.Duration
+	// refreshJitter spreads background refreshes by up to this fraction of
+	// refreshInterval either way, so replicas started together drift apart
+	// instead of querying Snowflake at the same moment.
+	refreshJitter float64
+
+	// queryTimeout bounds each individual Snowflake query
+	queryTimeout time.Duration
+
+	// scrapeTimeout bounds a whole scrape. Queries still running at the
+	// deadline are cancelled and the scrape reports what it gathered, with
+	// snowflake_up 0. Zero disables the deadline.
+	scrapeTimeout time.Duration
+
+	// queryRetries is how many times a query failing with a transient error
+	// is retried, waiting retryBackoff before the first retry and doubling
+	// the wait for each one after.
+	queryRetries int
+	retryBackoff time.Duration
+
+	// querySlots limits the number of queries in flight at once. Each
+	// query holds a slot while it runs.
+	querySlots chan struct{}
+
+	logger *slog.Logger
+
+	// enabledGroups switches metric groups on or off by name. Groups that
+	// are missing from the map are enabled, unless they are opt-in.
+	enabledGroups map[string]bool
+
+	// warehouseFilter restricts the per-warehouse queries to these
+	// warehouses. An empty filter matches every warehouse.
+	warehouseFilter []string
+
+	// tableStorageLimit caps the number of tables the table_storage group
+	// exports.
+	tableStorageLimit int
+
+	// usageSchema is the SNOWFLAKE schema, account_usage or
+	// organization_usage, that the groups with an organization-wide view
+	// read from.
+	usageSchema string
+
+	// clusteringTables lists the fully qualified tables the
+	// clustering_depth group measures.
+	clusteringTables []string
+
+	// queryCountByUser adds a user_name label to snowflake_query_count. It
+	// is off by default as it multiplies the series by the number of users.
+	queryCountByUser bool
+
+	// queryOverrides replaces the built-in query of a metric group, keyed
+	// by group name. See Config.Queries for the column contract.
+	queryOverrides map[string]string
+
+	// customMetrics are user-defined metrics collected after the built-in
+	// groups.
+	customMetrics []customMetric
 
 	// Prometheus metrics
-	wareouseCredits *prometheus.Desc
-	storageBytes    *prometheus.Desc
-	queryCount      *prometheus.Desc
-	concurrentQuery *prometheus.Desc
-	
+	warehouseCredits           *prometheus.Desc
+	warehouseComputeCredits    *prometheus.Desc
+	warehouseCloudCredits      *prometheus.Desc
+	storageBytes               *prometheus.Desc
+	queryCount                 *prometheus.Desc
+	queryCountUser             *prometheus.Desc
+	concurrentQuery            *prometheus.Desc
+	failedLogins               *prometheus.Desc
+	logins                     *prometheus.Desc
+	executionTime              *prometheus.Desc
+	bytesScanned               *prometheus.Desc
+	dataTransferBytes          *prometheus.Desc
+	automaticClusteringCredits *prometheus.Desc
+	materializedViewCredits    *prometheus.Desc
+	pipeCredits                *prometheus.Desc
+	pipeBytesInserted          *prometheus.Desc
+	taskRuns                   *prometheus.Desc
+	taskFailures               *prometheus.Desc
+	replicationCredits         *prometheus.Desc
+	replicationBytes           *prometheus.Desc
+	serverlessTaskCredits      *prometheus.Desc
+	queuedProvisioning         *prometheus.Desc
+	queuedOverload             *prometheus.Desc
+	warehouseRunning           *prometheus.Desc
+	runningWarehouses          *prometheus.Desc
+	warehouseAutoSuspend       *prometheus.Desc
+	warehouseAutoResume        *prometheus.Desc
+	userCredits                *prometheus.Desc
+	activeBytes                *prometheus.Desc
+	timeTravelBytes            *prometheus.Desc
+	failsafeBytes              *prometheus.Desc
+	stageBytes                 *prometheus.Desc
+	bytesSpilledLocal          *prometheus.Desc
+	bytesSpilledRemote         *prometheus.Desc
+	versionInfo                *prometheus.Desc
+	tableBytes                 *prometheus.Desc
+	failedQueries              *prometheus.Desc
+	warehouseAvgRunning        *prometheus.Desc
+	warehouseAvgQueued         *prometheus.Desc
+	activeUsers                *prometheus.Desc
+	queriesByStatus            *prometheus.Desc
+	loginsByClientIP           *prometheus.Desc
+	remainingBalance           *prometheus.Desc
+	dailyCredits               *prometheus.Desc
+	clusteringDepth            *prometheus.Desc
+	queryCountByDatabase       *prometheus.Desc
+	compilationTime            *prometheus.Desc
+	lookbackWindow             *prometheus.Desc
+	up                         *prometheus.Desc
+	scrapeDuration             *prometheus.Desc
+	queryDuration              *prometheus.Desc
+	lastSuccessTime            *prometheus.Desc
+	permissionError            *prometheus.Desc
+	openConnections            *prometheus.Desc
+	inUseConnections           *prometheus.Desc
+
+	// scrapeErrors counts failed queries and row scans across scrapes
+	scrapeErrors *prometheus.CounterVec
+
+	// reconnects counts replaced connections
+	reconnects prometheus.Counter
+
+	// permissions tracks the views queries were denied access to
+	permissions permissionTracker
+
 	mu sync.Mutex
+	// scrapeMu is held for the duration of a scrape, so a reload never
+	// changes the settings under a scrape in progress
+	scrapeMu sync.Mutex
+	// groupsMu guards the settings that decide the collected groups and
+	// their descriptors, which Describe reads without waiting for a scrape
+	groupsMu sync.RWMutex
 }
 
-func NewSnowflakeMetricsCollector(dsn string) (*SnowflakeMetricsCollector, error) {
-	db, err := sql.Open("snowflake", dsn)
+func NewSnowflakeMetricsCollector(dsn string, pool poolConfig, session ...string) (*SnowflakeMetricsCollector, error) {
+	open := func() (*sql.DB, error) {
+		db, err := openDB(dsn, session)
+		if err != nil {
+			return nil, err
+		}
+		pool.apply(db)
+		return db, nil
+	}
+
+	db, err := open()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Snowflake: %v", err)
+		return nil, err
 	}
+	c := newSnowflakeMetricsCollector(db)
+	c.open = open
+	return c, nil
+}
+
+// newSnowflakeMetricsCollector builds a collector around an already opened
+// database handle.
+func newSnowflakeMetricsCollector(db *sql.DB) *SnowflakeMetricsCollector {
+	c := &SnowflakeMetricsCollector{
+		db:       db,
+		lookback: defaultLookback,
+		cacheTTL: defaultCacheTTL,
+		now:      time.Now,
 
-	return &SnowflakeMetricsCollector{
-		db: db,
-		wareouseCredits: prometheus.NewDesc(
+		queryTimeout:  defaultQueryTimeout,
+		scrapeTimeout: defaultScrapeTimeout,
+		queryRetries:  defaultQueryRetries,
+		retryBackoff:  defaultRetryBackoff,
+		querySlots:    make(chan struct{}, defaultMaxConcurrentQueries),
+
+		tableStorageLimit: defaultTableStorageLimit,
+		usageSchema:       defaultUsageSchema,
+		logger:            slog.Default(),
+		warehouseCredits: prometheus.NewDesc(
 			"snowflake_warehouse_credits_used",
 			"Number of credits used by warehouse",
+			[]string{"warehouse_name", "size"},
+			nil,
+		),
+		warehouseComputeCredits: prometheus.NewDesc(
+			"snowflake_warehouse_credits_compute",
+			"Number of compute credits used by warehouse",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		warehouseCloudCredits: prometheus.NewDesc(
+			"snowflake_warehouse_credits_cloud_services",
+			"Number of cloud services credits used by warehouse",
 			[]string{"warehouse_name"},
 			nil,
 		),
@@ -51,113 +257,2574 @@ func NewSnowflakeMetricsCollector(dsn string) (*SnowflakeMetricsCollector, error
 			[]string{"warehouse_name", "query_type"},
 			nil,
 		),
+		queryCountUser: prometheus.NewDesc(
+			"snowflake_query_count",
+			"Number of queries executed",
+			[]string{"warehouse_name", "query_type", "user_name"},
+			nil,
+		),
 		concurrentQuery: prometheus.NewDesc(
 			"snowflake_concurrent_queries",
 			"Number of concurrent queries",
 			[]string{"warehouse_name"},
 			nil,
 		),
-	}, nil
+		failedLogins: prometheus.NewDesc(
+			"snowflake_failed_logins_total",
+			"Number of failed logins over the lookback window",
+			[]string{"user_name", "error_message"},
+			nil,
+		),
+		logins: prometheus.NewDesc(
+			"snowflake_logins_total",
+			"Number of successful logins over the lookback window",
+			[]string{"user_name", "client_type", "reported_client_type"},
+			nil,
+		),
+		executionTime: prometheus.NewDesc(
+			"snowflake_query_execution_time_seconds",
+			"Query execution time over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		bytesScanned: prometheus.NewDesc(
+			"snowflake_bytes_scanned_total",
+			"Bytes scanned by queries over the lookback window",
+			[]string{"warehouse_name", "query_type"},
+			nil,
+		),
+		dataTransferBytes: prometheus.NewDesc(
+			"snowflake_data_transfer_bytes_total",
+			"Bytes transferred between clouds and regions over the lookback window",
+			[]string{"source_cloud", "target_cloud", "source_region", "target_region", "transfer_type"},
+			nil,
+		),
+		automaticClusteringCredits: prometheus.NewDesc(
+			"snowflake_automatic_clustering_credits_used",
+			"Credits used by automatic clustering over the lookback window",
+			[]string{"table_name", "database_name"},
+			nil,
+		),
+		materializedViewCredits: prometheus.NewDesc(
+			"snowflake_materialized_view_credits_used",
+			"Credits used refreshing materialized views over the lookback window",
+			[]string{"table_name", "schema_name", "database_name"},
+			nil,
+		),
+		pipeCredits: prometheus.NewDesc(
+			"snowflake_pipe_credits_used",
+			"Credits used by Snowpipe over the lookback window",
+			[]string{"pipe_name"},
+			nil,
+		),
+		pipeBytesInserted: prometheus.NewDesc(
+			"snowflake_pipe_bytes_inserted_total",
+			"Bytes inserted by Snowpipe over the lookback window",
+			[]string{"pipe_name"},
+			nil,
+		),
+		taskRuns: prometheus.NewDesc(
+			"snowflake_task_runs_total",
+			"Task runs by final state over the lookback window",
+			[]string{"name", "database_name", "schema_name", "state"},
+			nil,
+		),
+		taskFailures: prometheus.NewDesc(
+			"snowflake_task_failures_total",
+			"Failed task runs over the lookback window",
+			[]string{"name", "database_name", "schema_name"},
+			nil,
+		),
+		replicationCredits: prometheus.NewDesc(
+			"snowflake_replication_credits_used",
+			"Credits used by database replication over the lookback window",
+			[]string{"database_name"},
+			nil,
+		),
+		replicationBytes: prometheus.NewDesc(
+			"snowflake_replication_bytes_transferred_total",
+			"Bytes transferred by database replication over the lookback window",
+			[]string{"database_name"},
+			nil,
+		),
+		serverlessTaskCredits: prometheus.NewDesc(
+			"snowflake_serverless_task_credits_used",
+			"Credits used by serverless tasks over the lookback window",
+			[]string{"task_name", "database_name", "schema_name"},
+			nil,
+		),
+		queuedProvisioning: prometheus.NewDesc(
+			"snowflake_query_queued_provisioning_seconds",
+			"Time queries spent queued for warehouse provisioning over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		queuedOverload: prometheus.NewDesc(
+			"snowflake_query_queued_overload_seconds",
+			"Time queries spent queued behind other queries over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		warehouseRunning: prometheus.NewDesc(
+			"snowflake_warehouse_running",
+			"Whether the warehouse is running (1) or suspended (0)",
+			[]string{"warehouse_name", "size"},
+			nil,
+		),
+		runningWarehouses: prometheus.NewDesc(
+			"snowflake_running_warehouses_total",
+			"Number of warehouses in the account that are currently running",
+			nil,
+			nil,
+		),
+		warehouseAutoSuspend: prometheus.NewDesc(
+			"snowflake_warehouse_auto_suspend_seconds",
+			"Idle time after which the warehouse suspends, 0 if it never auto-suspends",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		warehouseAutoResume: prometheus.NewDesc(
+			"snowflake_warehouse_auto_resume",
+			"Whether the warehouse resumes automatically (1) or not (0)",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		userCredits: prometheus.NewDesc(
+			"snowflake_user_credits_used",
+			"Estimated warehouse credits used by user over the lookback window",
+			[]string{"user_name"},
+			nil,
+		),
+		activeBytes: prometheus.NewDesc(
+			"snowflake_active_bytes",
+			"Bytes of active table storage by database",
+			[]string{"database_name"},
+			nil,
+		),
+		timeTravelBytes: prometheus.NewDesc(
+			"snowflake_time_travel_bytes",
+			"Bytes of Time Travel storage by database",
+			[]string{"database_name"},
+			nil,
+		),
+		failsafeBytes: prometheus.NewDesc(
+			"snowflake_failsafe_bytes",
+			"Bytes of Fail-safe storage by database",
+			[]string{"database_name"},
+			nil,
+		),
+		stageBytes: prometheus.NewDesc(
+			"snowflake_stage_bytes",
+			"Bytes stored in internal stages",
+			nil,
+			nil,
+		),
+		bytesSpilledLocal: prometheus.NewDesc(
+			"snowflake_bytes_spilled_local_total",
+			"Bytes spilled to local storage by queries over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		bytesSpilledRemote: prometheus.NewDesc(
+			"snowflake_bytes_spilled_remote_total",
+			"Bytes spilled to remote storage by queries over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		versionInfo: prometheus.NewDesc(
+			"snowflake_version_info",
+			"A metric with a constant '1' value labeled by the Snowflake version the account runs",
+			[]string{"version"},
+			nil,
+		),
+		tableBytes: prometheus.NewDesc(
+			"snowflake_table_bytes",
+			"Bytes of active storage by table",
+			[]string{"database_name", "schema_name", "table_name"},
+			nil,
+		),
+		failedQueries: prometheus.NewDesc(
+			"snowflake_failed_queries_total",
+			"Number of failed queries by error code over the lookback window",
+			[]string{"warehouse_name", "error_code"},
+			nil,
+		),
+		warehouseAvgRunning: prometheus.NewDesc(
+			"snowflake_warehouse_avg_running",
+			"Average number of queries running on the warehouse over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		warehouseAvgQueued: prometheus.NewDesc(
+			"snowflake_warehouse_avg_queued",
+			"Average number of queries queued on the warehouse over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		activeUsers: prometheus.NewDesc(
+			"snowflake_active_users",
+			"Number of distinct users that ran queries over the lookback window",
+			nil,
+			nil,
+		),
+		queriesByStatus: prometheus.NewDesc(
+			"snowflake_queries_by_status",
+			"Number of queries by execution status over the lookback window",
+			[]string{"warehouse_name", "execution_status"},
+			nil,
+		),
+		loginsByClientIP: prometheus.NewDesc(
+			"snowflake_logins_by_client_ip",
+			"Number of successful logins by user and client IP over the lookback window",
+			[]string{"user_name", "client_ip"},
+			nil,
+		),
+		remainingBalance: prometheus.NewDesc(
+			"snowflake_remaining_balance_credits",
+			"Remaining capacity balance of the organization contract on the latest reported day",
+			[]string{"currency"},
+			nil,
+		),
+		dailyCredits: prometheus.NewDesc(
+			"snowflake_daily_credits_used",
+			"Credits used today by service type",
+			[]string{"service_type"},
+			nil,
+		),
+		clusteringDepth: prometheus.NewDesc(
+			"snowflake_table_clustering_depth",
+			"Average clustering depth of a table with a clustering key",
+			[]string{"database_name", "schema_name", "table_name"},
+			nil,
+		),
+		queryCountByDatabase: prometheus.NewDesc(
+			"snowflake_query_count_by_database",
+			"Number of queries by database",
+			[]string{"database_name"},
+			nil,
+		),
+		compilationTime: prometheus.NewDesc(
+			"snowflake_query_compilation_time_seconds",
+			"Average query compilation time over the lookback window",
+			[]string{"warehouse_name"},
+			nil,
+		),
+		lookbackWindow: prometheus.NewDesc(
+			"snowflake_lookback_window_seconds",
+			"Lookback window used by the history queries",
+			nil,
+			nil,
+		),
+		up: prometheus.NewDesc(
+			"snowflake_up",
+			"Whether the last scrape of Snowflake succeeded",
+			nil,
+			nil,
+		),
+		scrapeDuration: prometheus.NewDesc(
+			"snowflake_scrape_duration_seconds",
+			"Time taken by the last scrape of Snowflake",
+			nil,
+			nil,
+		),
+		queryDuration: prometheus.NewDesc(
+			"snowflake_scrape_query_duration_seconds",
+			"Time taken by each query in the last scrape of Snowflake",
+			[]string{"query"},
+			nil,
+		),
+		lastSuccessTime: prometheus.NewDesc(
+			"snowflake_last_scrape_success_timestamp_seconds",
+			"Unix time of the last fully successful scrape of Snowflake, or 0 if none has succeeded",
+			nil,
+			nil,
+		),
+		permissionError: prometheus.NewDesc(
+			"snowflake_permission_error",
+			"Whether the last query of a view failed because the role lacks privileges on it",
+			[]string{"view"},
+			nil,
+		),
+		openConnections: prometheus.NewDesc(
+			"snowflake_db_open_connections",
+			"Number of open connections to Snowflake, both in use and idle",
+			nil,
+			nil,
+		),
+		inUseConnections: prometheus.NewDesc(
+			"snowflake_db_in_use_connections",
+			"Number of connections to Snowflake currently in use",
+			nil,
+			nil,
+		),
+		scrapeErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "snowflake_scrape_errors_total",
+				Help: "Number of failed Snowflake queries and row scans",
+			},
+			[]string{"query"},
+		),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snowflake_reconnects_total",
+			Help: "Number of times the Snowflake connection was re-established",
+		}),
+	}
+
+	// Start every query's error count at zero
+	for _, group := range c.metricGroups() {
+		c.scrapeErrors.WithLabelValues(group.name)
+	}
+	return c
 }
 
 func (c *SnowflakeMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.wareouseCredits
-	ch <- c.storageBytes
-	ch <- c.queryCount
-	ch <- c.concurrentQuery
+	// Only enabled groups are described, so a disabled group registers
+	// nothing. Collect runs the same groups, so a metric cannot be described
+	// without the query that emits it.
+	for _, group := range c.collectedGroups() {
+		for _, desc := range group.descs {
+			ch <- desc
+		}
+	}
+	ch <- c.lookbackWindow
+	ch <- c.up
+	ch <- c.scrapeDuration
+	ch <- c.queryDuration
+	ch <- c.lastSuccessTime
+	ch <- c.permissionError
+	ch <- c.openConnections
+	ch <- c.inUseConnections
+	c.scrapeErrors.Describe(ch)
+	c.reconnects.Describe(ch)
 }
 
+// Collect serves the cached metrics, refreshing them from Snowflake once the
+// cache TTL of any group has expired. Only the expired groups are queried
+// again. Concurrent scrapes wait for an in-progress refresh and then share
+// its result. With a refresh interval set, Collect never queries Snowflake
+// and serves the latest background snapshot instead. Queries run under
+// context.Background; the exporter serves requests through contextCollector
+// so they run under the request's context instead.
 func (c *SnowflakeMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectContext(context.Background(), ch)
+}
+
+// collectContext is Collect with any refresh it triggers running under ctx.
+func (c *SnowflakeMetricsCollector) collectContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Warehouse Credits
-	warehouseCreditsQuery := `
-		SELECT warehouse_name, SUM(credits_used) as total_credits 
-		FROM snowflake.account_usage.warehouse_metering_history 
-		WHERE start_time > dateadd(day, -1, current_timestamp()) 
-		GROUP BY warehouse_name
-	`
-	rows, err := c.db.Query(warehouseCreditsQuery)
-	if err != nil {
-		log.Printf("Error fetching warehouse credits: %v", err)
+	if c.refreshInterval > 0 {
+		if c.cached == nil {
+			// Nothing has been refreshed yet
+			ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		}
+		for _, metric := range c.cached {
+			ch <- metric
+		}
+		c.collectExporterMetrics(ch)
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var warehouseName string
-		var creditsUsed float64
-		if err := rows.Scan(&warehouseName, &creditsUsed); err != nil {
-			log.Printf("Error scanning warehouse credits: %v", err)
-			continue
+	now := c.now()
+	if c.cached == nil || !now.Before(c.expires) {
+		c.logger.Debug("Refreshing metrics from Snowflake")
+		metrics, err := c.scrape(ctx)
+		c.cached = metrics
+		// A failed scrape is served once but retried on the next Collect
+		if err == nil {
+			c.lastScrape = now
+			c.lastSuccess = now
+		} else {
+			c.lastScrape = time.Time{}
 		}
-		ch <- prometheus.MustNewConstMetric(
-			c.wareouseCredits,
-			prometheus.GaugeValue,
-			creditsUsed,
-			warehouseName,
-		)
+	} else {
+		c.logger.Debug("Serving cached metrics", "age", now.Sub(c.lastScrape))
 	}
 
-	// Storage Bytes
-	storageQuery := `
-		SELECT database_name, storage_bytes 
-		FROM snowflake.account_usage.database_storage_usage_history 
-		WHERE usage_date = current_date()
-	`
-	rows, err = c.db.Query(storageQuery)
+	for _, metric := range c.cached {
+		ch <- metric
+	}
+	c.collectExporterMetrics(ch)
+}
+
+// collectExporterMetrics emits the metrics about the exporter itself that
+// are tracked across scrapes rather than cached with them. The caller must
+// hold mu.
+func (c *SnowflakeMetricsCollector) collectExporterMetrics(ch chan<- prometheus.Metric) {
+	lastSuccess := 0.0
+	if !c.lastSuccess.IsZero() {
+		lastSuccess = float64(c.lastSuccess.UnixNano()) / 1e9
+	}
+	ch <- prometheus.MustNewConstMetric(c.lastSuccessTime, prometheus.GaugeValue, lastSuccess)
+	c.collectPermissionErrors(ch)
+
+	// Pool usage is read live, so it reflects the pool even between
+	// refreshes of the cached metrics
+	stats := c.conn().Stats()
+	ch <- prometheus.MustNewConstMetric(c.openConnections, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUseConnections, prometheus.GaugeValue, float64(stats.InUse))
+	c.scrapeErrors.Collect(ch)
+	c.reconnects.Collect(ch)
+}
+
+// refresh scrapes Snowflake and stores the result as the snapshot served by
+// Collect. The scrape runs without holding the lock so scrapes are never
+// blocked behind a refresh.
+func (c *SnowflakeMetricsCollector) refresh() error {
+	metrics, err := c.scrape(context.Background())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cached = metrics
+	if err == nil {
+		c.lastScrape = c.now()
+		c.lastSuccess = c.lastScrape
+	}
+	return err
+}
+
+// runRefresher refreshes the snapshot immediately and then every
+// refreshInterval, give or take the jitter, until ctx is cancelled.
+func (c *SnowflakeMetricsCollector) runRefresher(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		c.logger.Debug("Refreshing metrics from Snowflake")
+		c.refresh()
+		timer.Reset(c.refreshDelay())
+	}
+}
+
+// refreshDelay returns the wait before the next background refresh: the
+// refresh interval scaled by a random factor within the jitter band.
+func (c *SnowflakeMetricsCollector) refreshDelay() time.Duration {
+	factor := 1 + c.refreshJitter*(2*rand.Float64()-1)
+	return time.Duration(float64(c.refreshInterval) * factor)
+}
+
+// scrape runs collect under ctx and gathers the emitted metrics into a slice,
+// followed by snowflake_up reflecting whether every query succeeded and the
+// total scrape duration.
+func (c *SnowflakeMetricsCollector) scrape(ctx context.Context) ([]prometheus.Metric, error) {
+	c.scrapeMu.Lock()
+	defer c.scrapeMu.Unlock()
+
+	start := time.Now()
+	if c.scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.scrapeTimeout)
+		defer cancel()
+	}
+
+	metricCh := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.collect(ctx, metricCh)
+		close(metricCh)
+	}()
+
+	metrics := []prometheus.Metric{}
+	for metric := range metricCh {
+		metrics = append(metrics, metric)
+	}
+	err := <-errCh
+
+	up := 1.0
 	if err != nil {
-		log.Printf("Error fetching storage bytes: %v", err)
-		return
+		up = 0
 	}
-	defer rows.Close()
+	metrics = append(metrics,
+		prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up),
+		prometheus.MustNewConstMetric(c.scrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds()),
+	)
+	return metrics, err
+}
 
-	for rows.Next() {
-		var databaseName string
-		var storageBytes float64
-		if err := rows.Scan(&databaseName, &storageBytes); err != nil {
-			log.Printf("Error scanning storage bytes: %v", err)
+// groupSnapshot is the cached result of one metric group, including its
+// query duration.
+type groupSnapshot struct {
+	metrics []prometheus.Metric
+	scraped time.Time
+}
+
+// groupTTL returns how long the result of the named group is reused. With
+// background refreshes groups without an override are queried on every
+// refresh.
+func (c *SnowflakeMetricsCollector) groupTTL(name string) time.Duration {
+	if ttl, ok := c.groupTTLs[name]; ok {
+		return ttl
+	}
+	if c.refreshInterval > 0 {
+		return 0
+	}
+	return c.cacheTTL
+}
+
+// metricGroup is a set of metrics fetched together by a single query. The
+// name identifies the query in logs and in the query label, descs are the
+// metrics the group emits, whose names are listed in the same order in
+// metrics, and sources are the views or commands its query reads, as listed
+// by -list-metrics.
+type metricGroup struct {
+	name    string
+	collect func(ctx context.Context, ch chan<- prometheus.Metric) error
+	descs   []*prometheus.Desc
+	metrics []string
+	sources []string
+}
+
+// metricGroups lists the query-backed metric groups in collection order.
+func (c *SnowflakeMetricsCollector) metricGroups() []metricGroup {
+	return []metricGroup{
+		{"warehouse_credits", c.collectWarehouseCredits, []*prometheus.Desc{c.warehouseCredits, c.warehouseComputeCredits, c.warehouseCloudCredits}, []string{"snowflake_warehouse_credits_used", "snowflake_warehouse_credits_compute", "snowflake_warehouse_credits_cloud_services"}, []string{"account_usage.warehouse_metering_history", "account_usage.query_history"}},
+		{"storage_bytes", c.collectStorageBytes, []*prometheus.Desc{c.storageBytes}, []string{"snowflake_storage_bytes"}, []string{"account_usage.database_storage_usage_history"}},
+		{"query_count", c.collectQueryCount, []*prometheus.Desc{c.queryCountDesc()}, []string{"snowflake_query_count"}, []string{"account_usage.query_history"}},
+		{"concurrent_queries", c.collectConcurrentQueries, []*prometheus.Desc{c.concurrentQuery}, []string{"snowflake_concurrent_queries"}, []string{"account_usage.query_history"}},
+		{"failed_logins", c.collectFailedLogins, []*prometheus.Desc{c.failedLogins}, []string{"snowflake_failed_logins_total"}, []string{"account_usage.login_history"}},
+		{"logins", c.collectLogins, []*prometheus.Desc{c.logins}, []string{"snowflake_logins_total"}, []string{"account_usage.login_history"}},
+		{"execution_time", c.collectExecutionTime, []*prometheus.Desc{c.executionTime}, []string{"snowflake_query_execution_time_seconds"}, []string{"account_usage.query_history"}},
+		{"bytes_scanned", c.collectBytesScanned, []*prometheus.Desc{c.bytesScanned}, []string{"snowflake_bytes_scanned_total"}, []string{"account_usage.query_history"}},
+		{"data_transfer", c.collectDataTransfer, []*prometheus.Desc{c.dataTransferBytes}, []string{"snowflake_data_transfer_bytes_total"}, []string{c.usageSchema + ".data_transfer_history"}},
+		{"automatic_clustering", c.collectAutomaticClustering, []*prometheus.Desc{c.automaticClusteringCredits}, []string{"snowflake_automatic_clustering_credits_used"}, []string{c.usageSchema + ".automatic_clustering_history"}},
+		{"materialized_view_credits", c.collectMaterializedViewCredits, []*prometheus.Desc{c.materializedViewCredits}, []string{"snowflake_materialized_view_credits_used"}, []string{c.usageSchema + ".materialized_view_refresh_history"}},
+		{"pipe_usage", c.collectPipeUsage, []*prometheus.Desc{c.pipeCredits, c.pipeBytesInserted}, []string{"snowflake_pipe_credits_used", "snowflake_pipe_bytes_inserted_total"}, []string{c.usageSchema + ".pipe_usage_history"}},
+		{"task_history", c.collectTaskHistory, []*prometheus.Desc{c.taskRuns, c.taskFailures}, []string{"snowflake_task_runs_total", "snowflake_task_failures_total"}, []string{"account_usage.task_history"}},
+		{"replication_usage", c.collectReplicationUsage, []*prometheus.Desc{c.replicationCredits, c.replicationBytes}, []string{"snowflake_replication_credits_used", "snowflake_replication_bytes_transferred_total"}, []string{c.usageSchema + ".replication_usage_history"}},
+		{"serverless_task_credits", c.collectServerlessTaskCredits, []*prometheus.Desc{c.serverlessTaskCredits}, []string{"snowflake_serverless_task_credits_used"}, []string{c.usageSchema + ".serverless_task_history"}},
+		{"queued_time", c.collectQueuedTime, []*prometheus.Desc{c.queuedProvisioning, c.queuedOverload}, []string{"snowflake_query_queued_provisioning_seconds", "snowflake_query_queued_overload_seconds"}, []string{"account_usage.query_history"}},
+		{"warehouse_state", c.collectWarehouseState, []*prometheus.Desc{c.warehouseRunning, c.runningWarehouses, c.warehouseAutoSuspend, c.warehouseAutoResume}, []string{"snowflake_warehouse_running", "snowflake_running_warehouses_total", "snowflake_warehouse_auto_suspend_seconds", "snowflake_warehouse_auto_resume"}, []string{"SHOW WAREHOUSES"}},
+		{"user_credits", c.collectUserCredits, []*prometheus.Desc{c.userCredits}, []string{"snowflake_user_credits_used"}, []string{"account_usage.query_history", "account_usage.warehouse_metering_history"}},
+		{"storage_breakdown", c.collectStorageBreakdown, []*prometheus.Desc{c.activeBytes, c.timeTravelBytes, c.failsafeBytes}, []string{"snowflake_active_bytes", "snowflake_time_travel_bytes", "snowflake_failsafe_bytes"}, []string{"account_usage.table_storage_metrics"}},
+		{"stage_storage", c.collectStageStorage, []*prometheus.Desc{c.stageBytes}, []string{"snowflake_stage_bytes"}, []string{c.usageSchema + ".stage_storage_usage_history"}},
+		{"bytes_spilled", c.collectBytesSpilled, []*prometheus.Desc{c.bytesSpilledLocal, c.bytesSpilledRemote}, []string{"snowflake_bytes_spilled_local_total", "snowflake_bytes_spilled_remote_total"}, []string{"account_usage.query_history"}},
+		{"snowflake_version", c.collectSnowflakeVersion, []*prometheus.Desc{c.versionInfo}, []string{"snowflake_version_info"}, []string{"CURRENT_VERSION()"}},
+		{"table_storage", c.collectTableStorage, []*prometheus.Desc{c.tableBytes}, []string{"snowflake_table_bytes"}, []string{"account_usage.table_storage_metrics"}},
+		{"failed_queries", c.collectFailedQueries, []*prometheus.Desc{c.failedQueries}, []string{"snowflake_failed_queries_total"}, []string{"account_usage.query_history"}},
+		{"warehouse_load", c.collectWarehouseLoad, []*prometheus.Desc{c.warehouseAvgRunning, c.warehouseAvgQueued}, []string{"snowflake_warehouse_avg_running", "snowflake_warehouse_avg_queued"}, []string{"account_usage.warehouse_load_history"}},
+		{"active_users", c.collectActiveUsers, []*prometheus.Desc{c.activeUsers}, []string{"snowflake_active_users"}, []string{"account_usage.query_history"}},
+		{"queries_by_status", c.collectQueriesByStatus, []*prometheus.Desc{c.queriesByStatus}, []string{"snowflake_queries_by_status"}, []string{"account_usage.query_history"}},
+		{"logins_by_client_ip", c.collectLoginsByClientIP, []*prometheus.Desc{c.loginsByClientIP}, []string{"snowflake_logins_by_client_ip"}, []string{"account_usage.login_history"}},
+		{"remaining_balance", c.collectRemainingBalance, []*prometheus.Desc{c.remainingBalance}, []string{"snowflake_remaining_balance_credits"}, []string{"organization_usage.remaining_balance_daily"}},
+		{"daily_credits", c.collectDailyCredits, []*prometheus.Desc{c.dailyCredits}, []string{"snowflake_daily_credits_used"}, []string{c.usageSchema + ".metering_daily_history"}},
+		{"clustering_depth", c.collectClusteringDepth, []*prometheus.Desc{c.clusteringDepth}, []string{"snowflake_table_clustering_depth"}, []string{"SYSTEM$CLUSTERING_DEPTH"}},
+		{"query_count_by_database", c.collectQueryCountByDatabase, []*prometheus.Desc{c.queryCountByDatabase}, []string{"snowflake_query_count_by_database"}, []string{"account_usage.query_history"}},
+		{"compilation_time", c.collectCompilationTime, []*prometheus.Desc{c.compilationTime}, []string{"snowflake_query_compilation_time_seconds"}, []string{"account_usage.query_history"}},
+	}
+}
+
+// metricGroupNames returns the names of all query-backed metric groups.
+func metricGroupNames() []string {
+	var names []string
+	for _, group := range (&SnowflakeMetricsCollector{}).metricGroups() {
+		names = append(names, group.name)
+	}
+	return names
+}
+
+// optInGroups are the metric groups that are disabled unless enabled
+// explicitly, because of the number of series they can produce.
+var optInGroups = map[string]bool{
+	"table_storage":       true,
+	"logins_by_client_ip": true,
+	"remaining_balance":   true,
+	"clustering_depth":    true,
+}
+
+// enabledMetricGroups returns the built-in metric groups that are enabled, in
+// collection order.
+func (c *SnowflakeMetricsCollector) enabledMetricGroups() []metricGroup {
+	var groups []metricGroup
+	for _, group := range c.metricGroups() {
+		enabled, ok := c.enabledGroups[group.name]
+		if !ok {
+			enabled = !optInGroups[group.name]
+		}
+		if !enabled {
 			continue
 		}
-		ch <- prometheus.MustNewConstMetric(
-			c.storageBytes,
-			prometheus.GaugeValue,
-			storageBytes,
-			databaseName,
-		)
+		groups = append(groups, group)
 	}
+	return groups
 }
 
-func main() {
-	// Snowflake connection parameters
-	dsn := fmt.Sprintf("%s:%s@%s/%s/%s/%s", 
-		os.Getenv("SNOWFLAKE_USERNAME"), 
-		os.Getenv("SNOWFLAKE_PASSWORD"), 
-		os.Getenv("SNOWFLAKE_ACCOUNT"), 
-		os.Getenv("SNOWFLAKE_DATABASE"), 
-		os.Getenv("SNOWFLAKE_SCHEMA"), 
-		os.Getenv("SNOWFLAKE_WAREHOUSE"),
+// collectedGroups returns the enabled built-in groups followed by the custom
+// metric groups: every group Describe and collect iterate over. The list is
+// built under groupsMu, so a reload cannot change it half way.
+func (c *SnowflakeMetricsCollector) collectedGroups() []metricGroup {
+	c.groupsMu.RLock()
+	defer c.groupsMu.RUnlock()
+	return append(c.enabledMetricGroups(), c.customGroups()...)
+}
+
+// collect queries Snowflake and emits the resulting metrics. Groups whose
+// cached result is still within their TTL are served from the cache instead.
+// A failing group does not stop the others and is not cached; the first
+// error in group order is returned. Groups still running when ctx is done
+// are cancelled.
+func (c *SnowflakeMetricsCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	// Lookback Window
+	ch <- prometheus.MustNewConstMetric(
+		c.lookbackWindow,
+		prometheus.GaugeValue,
+		c.lookback.Seconds(),
 	)
 
-	// Create Snowflake metrics collector
-	collector, err := NewSnowflakeMetricsCollector(dsn)
-	if err != nil {
-		log.Fatalf("Failed to create Snowflake metrics collector: %v", err)
+	// Groups run concurrently, each under its own query timeout, so the
+	// scrape takes about as long as the slowest query. Concurrency is still
+	// capped by the query slots and the connection pool: queries beyond
+	// either limit wait their turn, and that wait counts against their
+	// timeout.
+	groups := c.collectedGroups()
+
+	now := c.now()
+	errs := make([]error, len(groups))
+	fresh := make([][]prometheus.Metric, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		if snap, ok := c.groupCache[group.name]; ok && now.Sub(snap.scraped) < c.groupTTL(group.name) {
+			for _, metric := range snap.metrics {
+				ch <- metric
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			groupCh, gathered := gatherMetrics(ch)
+			errs[i] = c.collectGroup(ctx, group, groupCh)
+			fresh[i] = gathered()
+		}()
+	}
+	wg.Wait()
+
+	if c.groupCache == nil {
+		c.groupCache = map[string]groupSnapshot{}
+	}
+	c.expires = time.Time{}
+	for i, group := range groups {
+		if fresh[i] != nil {
+			if errs[i] == nil {
+				c.groupCache[group.name] = groupSnapshot{metrics: fresh[i], scraped: now}
+			} else {
+				delete(c.groupCache, group.name)
+			}
+		}
+		expires := now
+		if snap, ok := c.groupCache[group.name]; ok {
+			expires = snap.scraped.Add(c.groupTTL(group.name))
+		}
+		if c.expires.IsZero() || expires.Before(c.expires) {
+			c.expires = expires
+		}
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		c.logger.Warn("Scrape deadline exceeded, reporting partial metrics", "timeout", c.scrapeTimeout)
+	case context.Canceled:
+		c.logger.Warn("Scrape cancelled, reporting partial metrics")
 	}
 
-	// Register collector with Prometheus
-	prometheus.MustRegister(collector)
+	// A dead connection fails every group the same way, so check it once
+	for _, err := range errs {
+		if err != nil && isConnectionError(err) {
+			c.reconnect()
+			break
+		}
+	}
 
-	// Expose metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
-	
-	// Start server
-	port := os.Getenv("EXPORTER_PORT")
-	if port == "" {
-		port = "9090"
-	}
-	
-	log.Printf("Starting Snowflake Prometheus Exporter on :%s", port)
-	log.Fatal(http.ListenAndServe(":" + port, nil))
-}
\ No newline at end of file
+	// Report the first failing group in collection order
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectGroup runs a single metric group under the query timeout, emitting
+// its query duration and recording a failure in the logs and scrape errors.
+// At debug level it also logs the samples and duration of every group, so
+// the exporter's activity can be matched up with query_history.
+func (c *SnowflakeMetricsCollector) collectGroup(ctx context.Context, group metricGroup, ch chan<- prometheus.Metric) error {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout)
+	defer cancel()
+
+	out, samples := ch, func() int { return 0 }
+	debug := c.logger.Enabled(ctx, slog.LevelDebug)
+	if debug {
+		out, samples = countMetrics(ch)
+	}
+	err := group.collect(ctx, out)
+	duration := time.Since(start)
+	ch <- prometheus.MustNewConstMetric(
+		c.queryDuration,
+		prometheus.GaugeValue,
+		duration.Seconds(),
+		group.name,
+	)
+	if debug {
+		c.logger.Debug("Ran query", "query", group.name, "samples", samples(), "duration", duration, "failed", err != nil)
+	}
+	if err != nil {
+		c.logger.Error("Error fetching metrics", "query", group.name, "err", err)
+		c.scrapeErrors.WithLabelValues(group.name).Inc()
+	}
+	return err
+}
+
+// gatherMetrics returns a channel forwarding to ch and a function that closes
+// it and returns the metrics that were forwarded.
+func gatherMetrics(ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func() []prometheus.Metric) {
+	in := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		metrics := []prometheus.Metric{}
+		for m := range in {
+			ch <- m
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	return in, func() []prometheus.Metric {
+		close(in)
+		return <-done
+	}
+}
+
+// countMetrics returns a channel forwarding to ch and a function that closes
+// it and returns how many metrics were forwarded.
+func countMetrics(ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func() int) {
+	in := make(chan prometheus.Metric)
+	done := make(chan int)
+	go func() {
+		n := 0
+		for m := range in {
+			ch <- m
+			n++
+		}
+		done <- n
+	}()
+	return in, func() int {
+		close(in)
+		return <-done
+	}
+}
+
+// collectWarehouseCredits emits the credits used by each warehouse over the
+// lookback window, in total and split into compute and cloud services
+// credits, which are billed differently. The total is labeled with the
+// warehouse size, taken from its latest query in the window since metering
+// history has no size; a warehouse resized during the window reports its
+// latest size, and one that ran no queries an empty size.
+func (c *SnowflakeMetricsCollector) collectWarehouseCredits(ctx context.Context, ch chan<- prometheus.Metric) error {
+	filter, args := warehouseFilterClause(c.warehouseFilter)
+	lookback := lookbackStart(c.lookback)
+	warehouseCreditsQuery := fmt.Sprintf(`
+		SELECT warehouse_name, SUM(credits_used) as total_credits, 
+			SUM(credits_used_compute) as compute_credits, 
+			SUM(credits_used_cloud_services) as cloud_services_credits, 
+			ANY_VALUE(warehouse_size) as warehouse_size 
+		FROM snowflake.account_usage.warehouse_metering_history 
+		LEFT JOIN (
+			SELECT warehouse_name, warehouse_size 
+			FROM snowflake.account_usage.query_history 
+			WHERE start_time > %s AND warehouse_size IS NOT NULL 
+			QUALIFY ROW_NUMBER() OVER (PARTITION BY warehouse_name ORDER BY start_time DESC) = 1
+		) latest_size USING (warehouse_name) 
+		WHERE start_time > %s%s 
+		GROUP BY warehouse_name
+	`, lookback, lookback, filter)
+	rows, err := c.query(ctx, "warehouse_credits", warehouseCreditsQuery, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName, warehouseSize sql.NullString
+		var creditsUsed, computeCredits, cloudCredits sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &creditsUsed, &computeCredits, &cloudCredits, &warehouseSize); err != nil {
+			c.logger.Error("Error scanning warehouse credits", "err", err)
+			c.scrapeErrors.WithLabelValues("warehouse_credits").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "warehouse_credits")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.warehouseCredits,
+			prometheus.GaugeValue,
+			creditsUsed.Float64,
+			warehouseName.String,
+			warehouseSize.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.warehouseComputeCredits,
+			prometheus.GaugeValue,
+			computeCredits.Float64,
+			warehouseName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.warehouseCloudCredits,
+			prometheus.GaugeValue,
+			cloudCredits.Float64,
+			warehouseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectStorageBytes emits today's storage usage for each database.
+func (c *SnowflakeMetricsCollector) collectStorageBytes(ctx context.Context, ch chan<- prometheus.Metric) error {
+	storageQuery := `
+		SELECT database_name, storage_bytes 
+		FROM snowflake.account_usage.database_storage_usage_history 
+		WHERE usage_date = current_date()
+	`
+	rows, err := c.query(ctx, "storage_bytes", storageQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var databaseName sql.NullString
+		var storageBytes sql.NullFloat64
+		if err := rows.Scan(&databaseName, &storageBytes); err != nil {
+			c.logger.Error("Error scanning storage bytes", "err", err)
+			c.scrapeErrors.WithLabelValues("storage_bytes").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !databaseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "storage_bytes")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.storageBytes,
+			prometheus.GaugeValue,
+			storageBytes.Float64,
+			databaseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectQueryCount emits the number of queries executed over the lookback
+// window, grouped by warehouse and query type.
+func (c *SnowflakeMetricsCollector) collectQueryCount(ctx context.Context, ch chan<- prometheus.Metric) error {
+	filter, args := warehouseFilterClause(c.warehouseFilter)
+	columns := "warehouse_name, query_type"
+	if c.queryCountByUser {
+		columns += ", user_name"
+	}
+	queryCountQuery := fmt.Sprintf(`
+		SELECT %s, COUNT(*) as query_count 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s%s 
+		GROUP BY %s
+	`, columns, lookbackStart(c.lookback), filter, columns)
+	rows, err := c.query(ctx, "query_count", queryCountQuery, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var queryType sql.NullString
+		var userName sql.NullString
+		var queryCount float64
+		dest := []interface{}{&warehouseName, &queryType, &queryCount}
+		if c.queryCountByUser {
+			dest = []interface{}{&warehouseName, &queryType, &userName, &queryCount}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			c.logger.Error("Error scanning query count", "err", err)
+			c.scrapeErrors.WithLabelValues("query_count").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid || !queryType.Valid || (c.queryCountByUser && !userName.Valid) {
+			c.logger.Debug("Skipping row with NULL name", "query", "query_count")
+			continue
+		}
+		labels := []string{warehouseName.String, queryType.String}
+		if c.queryCountByUser {
+			labels = append(labels, userName.String)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.queryCountDesc(),
+			prometheus.GaugeValue,
+			queryCount,
+			labels...,
+		)
+	}
+	return rows.Err()
+}
+
+// queryCountDesc returns the descriptor of snowflake_query_count, which has
+// a user_name label when queryCountByUser is set.
+func (c *SnowflakeMetricsCollector) queryCountDesc() *prometheus.Desc {
+	if c.queryCountByUser {
+		return c.queryCountUser
+	}
+	return c.queryCount
+}
+
+// runningQueryWindow bounds how far back collectConcurrentQueries looks for
+// running queries. No statement runs longer than the largest statement
+// timeout Snowflake allows, seven days, so the bound loses none of them while
+// sparing a scan of the whole query history.
+const runningQueryWindow = 7 * 24 * time.Hour
+
+// collectConcurrentQueries emits the number of queries currently running on
+// each warehouse.
+func (c *SnowflakeMetricsCollector) collectConcurrentQueries(ctx context.Context, ch chan<- prometheus.Metric) error {
+	concurrentQueriesQuery := fmt.Sprintf(`
+		SELECT warehouse_name, COUNT(*) as concurrent_queries 
+		FROM snowflake.account_usage.query_history 
+		WHERE execution_status = 'RUNNING' 
+		AND start_time > %s
+		GROUP BY warehouse_name
+	`, lookbackStart(runningQueryWindow))
+	rows, err := c.query(ctx, "concurrent_queries", concurrentQueriesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var concurrentQueries float64
+		if err := rows.Scan(&warehouseName, &concurrentQueries); err != nil {
+			c.logger.Error("Error scanning concurrent queries", "err", err)
+			c.scrapeErrors.WithLabelValues("concurrent_queries").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "concurrent_queries")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.concurrentQuery,
+			prometheus.GaugeValue,
+			concurrentQueries,
+			warehouseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectFailedLogins emits the number of failed logins over the lookback
+// window, grouped by user and error message.
+func (c *SnowflakeMetricsCollector) collectFailedLogins(ctx context.Context, ch chan<- prometheus.Metric) error {
+	failedLoginsQuery := fmt.Sprintf(`
+		SELECT user_name, error_message, COUNT(*) as failed_logins 
+		FROM snowflake.account_usage.login_history 
+		WHERE is_success = 'NO' AND event_timestamp > %s 
+		GROUP BY user_name, error_message
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "failed_logins", failedLoginsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userName sql.NullString
+		var errorMessage sql.NullString
+		var failedLogins float64
+		if err := rows.Scan(&userName, &errorMessage, &failedLogins); err != nil {
+			c.logger.Error("Error scanning failed logins", "err", err)
+			c.scrapeErrors.WithLabelValues("failed_logins").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !userName.Valid || !errorMessage.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "failed_logins")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.failedLogins,
+			prometheus.GaugeValue,
+			failedLogins,
+			userName.String,
+			errorMessage.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectLogins emits the number of successful logins over the lookback
+// window, grouped by user and client. client_type is the driver family taken
+// from the first part of reported_client_type, e.g. JDBC for JDBC_DRIVER.
+func (c *SnowflakeMetricsCollector) collectLogins(ctx context.Context, ch chan<- prometheus.Metric) error {
+	loginsQuery := fmt.Sprintf(`
+		SELECT user_name, SPLIT_PART(reported_client_type, '_', 1) as client_type, reported_client_type, COUNT(*) as logins 
+		FROM snowflake.account_usage.login_history 
+		WHERE is_success = 'YES' AND event_timestamp > %s 
+		GROUP BY user_name, client_type, reported_client_type
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "logins", loginsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userName sql.NullString
+		var clientType sql.NullString
+		var reportedClientType sql.NullString
+		var logins float64
+		if err := rows.Scan(&userName, &clientType, &reportedClientType, &logins); err != nil {
+			c.logger.Error("Error scanning logins", "err", err)
+			c.scrapeErrors.WithLabelValues("logins").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !userName.Valid || !clientType.Valid || !reportedClientType.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "logins")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.logins,
+			prometheus.GaugeValue,
+			logins,
+			userName.String,
+			clientType.String,
+			reportedClientType.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectExecutionTime emits a summary of query execution times per
+// warehouse over the lookback window. query_history reports execution_time
+// in milliseconds; the median and 95th percentile are computed in Snowflake
+// with APPROX_PERCENTILE and converted to seconds along with the total.
+func (c *SnowflakeMetricsCollector) collectExecutionTime(ctx context.Context, ch chan<- prometheus.Metric) error {
+	executionTimeQuery := fmt.Sprintf(`
+		SELECT warehouse_name, COUNT(*) as query_count, SUM(execution_time) as total_execution_ms, 
+			APPROX_PERCENTILE(execution_time, 0.5) as p50_execution_ms, 
+			APPROX_PERCENTILE(execution_time, 0.95) as p95_execution_ms 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s AND warehouse_name IS NOT NULL 
+		GROUP BY warehouse_name
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "execution_time", executionTimeQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var queryCount sql.NullInt64
+		var totalMs, p50Ms, p95Ms sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &queryCount, &totalMs, &p50Ms, &p95Ms); err != nil {
+			c.logger.Error("Error scanning execution time", "err", err)
+			c.scrapeErrors.WithLabelValues("execution_time").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "execution_time")
+			continue
+		}
+		ch <- prometheus.MustNewConstSummary(
+			c.executionTime,
+			uint64(queryCount.Int64),
+			millisecondsToSeconds(totalMs.Float64),
+			map[float64]float64{
+				0.5:  millisecondsToSeconds(p50Ms.Float64),
+				0.95: millisecondsToSeconds(p95Ms.Float64),
+			},
+			warehouseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// millisecondsToSeconds converts the millisecond durations reported by
+// account_usage views to seconds.
+func millisecondsToSeconds(ms float64) float64 {
+	return ms / 1000
+}
+
+// collectBytesScanned emits the bytes scanned per warehouse and query type
+// over the lookback window. bytes_scanned is NULL for queries that did not
+// read any table data, so a NULL sum is reported as zero.
+func (c *SnowflakeMetricsCollector) collectBytesScanned(ctx context.Context, ch chan<- prometheus.Metric) error {
+	bytesScannedQuery := fmt.Sprintf(`
+		SELECT warehouse_name, query_type, SUM(bytes_scanned) as bytes_scanned 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s AND warehouse_name IS NOT NULL 
+		GROUP BY warehouse_name, query_type
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "bytes_scanned", bytesScannedQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var queryType sql.NullString
+		var bytesScanned sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &queryType, &bytesScanned); err != nil {
+			c.logger.Error("Error scanning bytes scanned", "err", err)
+			c.scrapeErrors.WithLabelValues("bytes_scanned").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid || !queryType.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "bytes_scanned")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.bytesScanned,
+			prometheus.GaugeValue,
+			bytesScanned.Float64,
+			warehouseName.String,
+			queryType.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectDataTransfer emits the bytes transferred between clouds and regions
+// over the lookback window. Regions are NULL for some transfer types and are
+// reported as empty labels.
+func (c *SnowflakeMetricsCollector) collectDataTransfer(ctx context.Context, ch chan<- prometheus.Metric) error {
+	dataTransferQuery := fmt.Sprintf(`
+		SELECT source_cloud, target_cloud, source_region, target_region, transfer_type, SUM(bytes_transferred) as bytes_transferred 
+		FROM %s 
+		WHERE start_time > %s 
+		GROUP BY source_cloud, target_cloud, source_region, target_region, transfer_type
+	`, c.usageView("data_transfer_history"), lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "data_transfer", dataTransferQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceCloud, targetCloud sql.NullString
+		var sourceRegion, targetRegion sql.NullString
+		var transferType sql.NullString
+		var bytesTransferred sql.NullFloat64
+		if err := rows.Scan(&sourceCloud, &targetCloud, &sourceRegion, &targetRegion, &transferType, &bytesTransferred); err != nil {
+			c.logger.Error("Error scanning data transfer", "err", err)
+			c.scrapeErrors.WithLabelValues("data_transfer").Inc()
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.dataTransferBytes,
+			prometheus.GaugeValue,
+			bytesTransferred.Float64,
+			sourceCloud.String,
+			targetCloud.String,
+			sourceRegion.String,
+			targetRegion.String,
+			transferType.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectAutomaticClustering emits the credits used by automatic clustering
+// for each table over the lookback window.
+func (c *SnowflakeMetricsCollector) collectAutomaticClustering(ctx context.Context, ch chan<- prometheus.Metric) error {
+	automaticClusteringQuery := fmt.Sprintf(`
+		SELECT table_name, database_name, SUM(credits_used) as total_credits 
+		FROM %s 
+		WHERE start_time > %s 
+		GROUP BY table_name, database_name
+	`, c.usageView("automatic_clustering_history"), lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "automatic_clustering", automaticClusteringQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tableName sql.NullString
+		var databaseName sql.NullString
+		var creditsUsed sql.NullFloat64
+		if err := rows.Scan(&tableName, &databaseName, &creditsUsed); err != nil {
+			c.logger.Error("Error scanning automatic clustering credits", "err", err)
+			c.scrapeErrors.WithLabelValues("automatic_clustering").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !tableName.Valid || !databaseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "automatic_clustering")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.automaticClusteringCredits,
+			prometheus.GaugeValue,
+			creditsUsed.Float64,
+			tableName.String,
+			databaseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectMaterializedViewCredits emits the credits used refreshing each
+// materialized view over the lookback window. Rows without credits are
+// skipped.
+func (c *SnowflakeMetricsCollector) collectMaterializedViewCredits(ctx context.Context, ch chan<- prometheus.Metric) error {
+	materializedViewQuery := fmt.Sprintf(`
+		SELECT table_name, schema_name, database_name, SUM(credits_used) as total_credits 
+		FROM %s 
+		WHERE start_time > %s 
+		GROUP BY table_name, schema_name, database_name
+	`, c.usageView("materialized_view_refresh_history"), lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "materialized_view_credits", materializedViewQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tableName, schemaName, databaseName sql.NullString
+		var creditsUsed sql.NullFloat64
+		if err := rows.Scan(&tableName, &schemaName, &databaseName, &creditsUsed); err != nil {
+			c.logger.Error("Error scanning materialized view credits", "err", err)
+			c.scrapeErrors.WithLabelValues("materialized_view_credits").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !tableName.Valid || !schemaName.Valid || !databaseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "materialized_view_credits")
+			continue
+		}
+		if !creditsUsed.Valid {
+			c.logger.Debug("Skipping row with NULL credits", "query", "materialized_view_credits")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.materializedViewCredits,
+			prometheus.GaugeValue,
+			creditsUsed.Float64,
+			tableName.String,
+			schemaName.String,
+			databaseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectPipeUsage emits the credits used and bytes inserted by each
+// Snowpipe over the lookback window.
+func (c *SnowflakeMetricsCollector) collectPipeUsage(ctx context.Context, ch chan<- prometheus.Metric) error {
+	pipeUsageQuery := fmt.Sprintf(`
+		SELECT pipe_name, SUM(credits_used) as total_credits, SUM(bytes_inserted) as bytes_inserted 
+		FROM %s 
+		WHERE start_time > %s 
+		GROUP BY pipe_name
+	`, c.usageView("pipe_usage_history"), lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "pipe_usage", pipeUsageQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pipeName sql.NullString
+		var creditsUsed sql.NullFloat64
+		var bytesInserted sql.NullFloat64
+		if err := rows.Scan(&pipeName, &creditsUsed, &bytesInserted); err != nil {
+			c.logger.Error("Error scanning pipe usage", "err", err)
+			c.scrapeErrors.WithLabelValues("pipe_usage").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !pipeName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "pipe_usage")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.pipeCredits,
+			prometheus.GaugeValue,
+			creditsUsed.Float64,
+			pipeName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.pipeBytesInserted,
+			prometheus.GaugeValue,
+			bytesInserted.Float64,
+			pipeName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectTaskHistory emits the number of task runs per task and final state
+// over the lookback window, along with the number of failed runs per task.
+// Every task that ran reports a failure count, zero included, so alerts on
+// it do not depend on a failure having happened first.
+func (c *SnowflakeMetricsCollector) collectTaskHistory(ctx context.Context, ch chan<- prometheus.Metric) error {
+	taskHistoryQuery := fmt.Sprintf(`
+		SELECT name, database_name, schema_name, state, COUNT(*) as task_runs 
+		FROM snowflake.account_usage.task_history 
+		WHERE completed_time > %s 
+		GROUP BY name, database_name, schema_name, state
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "task_history", taskHistoryQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type task struct{ name, database, schema string }
+	var tasks []task
+	failures := map[task]float64{}
+	for rows.Next() {
+		var name, databaseName, schemaName, state sql.NullString
+		var taskRuns float64
+		if err := rows.Scan(&name, &databaseName, &schemaName, &state, &taskRuns); err != nil {
+			c.logger.Error("Error scanning task history", "err", err)
+			c.scrapeErrors.WithLabelValues("task_history").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !name.Valid || !databaseName.Valid || !schemaName.Valid || !state.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "task_history")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.taskRuns,
+			prometheus.GaugeValue,
+			taskRuns,
+			name.String,
+			databaseName.String,
+			schemaName.String,
+			state.String,
+		)
+
+		key := task{name.String, databaseName.String, schemaName.String}
+		if _, ok := failures[key]; !ok {
+			tasks = append(tasks, key)
+			failures[key] = 0
+		}
+		if state.String == "FAILED" {
+			failures[key] += taskRuns
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range tasks {
+		ch <- prometheus.MustNewConstMetric(
+			c.taskFailures,
+			prometheus.GaugeValue,
+			failures[key],
+			key.name,
+			key.database,
+			key.schema,
+		)
+	}
+	return nil
+}
+
+// collectReplicationUsage emits the credits used and bytes transferred by
+// database replication over the lookback window.
+func (c *SnowflakeMetricsCollector) collectReplicationUsage(ctx context.Context, ch chan<- prometheus.Metric) error {
+	replicationQuery := fmt.Sprintf(`
+		SELECT database_name, SUM(credits_used) as total_credits, SUM(bytes_transferred) as bytes_transferred 
+		FROM %s 
+		WHERE start_time > %s 
+		GROUP BY database_name
+	`, c.usageView("replication_usage_history"), lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "replication_usage", replicationQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var databaseName sql.NullString
+		var creditsUsed sql.NullFloat64
+		var bytesTransferred sql.NullFloat64
+		if err := rows.Scan(&databaseName, &creditsUsed, &bytesTransferred); err != nil {
+			c.logger.Error("Error scanning replication usage", "err", err)
+			c.scrapeErrors.WithLabelValues("replication_usage").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !databaseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "replication_usage")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.replicationCredits,
+			prometheus.GaugeValue,
+			creditsUsed.Float64,
+			databaseName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.replicationBytes,
+			prometheus.GaugeValue,
+			bytesTransferred.Float64,
+			databaseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectServerlessTaskCredits emits the credits used by each serverless
+// task over the lookback window.
+func (c *SnowflakeMetricsCollector) collectServerlessTaskCredits(ctx context.Context, ch chan<- prometheus.Metric) error {
+	serverlessTaskQuery := fmt.Sprintf(`
+		SELECT task_name, database_name, schema_name, SUM(credits_used) as total_credits 
+		FROM %s 
+		WHERE start_time > %s 
+		GROUP BY task_name, database_name, schema_name
+	`, c.usageView("serverless_task_history"), lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "serverless_task_credits", serverlessTaskQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskName, databaseName, schemaName sql.NullString
+		var creditsUsed sql.NullFloat64
+		if err := rows.Scan(&taskName, &databaseName, &schemaName, &creditsUsed); err != nil {
+			c.logger.Error("Error scanning serverless task credits", "err", err)
+			c.scrapeErrors.WithLabelValues("serverless_task_credits").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !taskName.Valid || !databaseName.Valid || !schemaName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "serverless_task_credits")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.serverlessTaskCredits,
+			prometheus.GaugeValue,
+			creditsUsed.Float64,
+			taskName.String,
+			databaseName.String,
+			schemaName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectQueuedTime emits the total time queries spent queued per warehouse
+// over the lookback window, split by whether they waited for the warehouse
+// to provision or for running queries to finish.
+func (c *SnowflakeMetricsCollector) collectQueuedTime(ctx context.Context, ch chan<- prometheus.Metric) error {
+	queuedTimeQuery := fmt.Sprintf(`
+		SELECT warehouse_name, SUM(queued_provisioning_time) as queued_provisioning_ms, SUM(queued_overload_time) as queued_overload_ms 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s AND warehouse_name IS NOT NULL 
+		GROUP BY warehouse_name
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "queued_time", queuedTimeQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var provisioningMs, overloadMs sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &provisioningMs, &overloadMs); err != nil {
+			c.logger.Error("Error scanning queued time", "err", err)
+			c.scrapeErrors.WithLabelValues("queued_time").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "queued_time")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.queuedProvisioning,
+			prometheus.GaugeValue,
+			millisecondsToSeconds(provisioningMs.Float64),
+			warehouseName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.queuedOverload,
+			prometheus.GaugeValue,
+			millisecondsToSeconds(overloadMs.Float64),
+			warehouseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectWarehouseState emits whether each warehouse is currently running,
+// how many are running in total and each warehouse's auto-suspend and
+// auto-resume settings, read from SHOW WAREHOUSES.
+// The command's output columns vary between Snowflake releases, so only the
+// columns needed are picked out by name; the settings are skipped when their
+// columns are missing.
+func (c *SnowflakeMetricsCollector) collectWarehouseState(ctx context.Context, ch chan<- prometheus.Metric) error {
+	rows, err := c.query(ctx, "warehouse_state", "SHOW WAREHOUSES")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	nameIndex, stateIndex, sizeIndex := -1, -1, -1
+	autoSuspendIndex, autoResumeIndex := -1, -1
+	for i, column := range columns {
+		switch strings.ToLower(column) {
+		case "name":
+			nameIndex = i
+		case "state":
+			stateIndex = i
+		case "size":
+			sizeIndex = i
+		case "auto_suspend":
+			autoSuspendIndex = i
+		case "auto_resume":
+			autoResumeIndex = i
+		}
+	}
+	if nameIndex < 0 || stateIndex < 0 || sizeIndex < 0 {
+		return fmt.Errorf("SHOW WAREHOUSES returned no name, state or size column")
+	}
+
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	runningCount := 0.0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			c.logger.Error("Error scanning warehouse state", "err", err)
+			c.scrapeErrors.WithLabelValues("warehouse_state").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !values[nameIndex].Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "warehouse_state")
+			continue
+		}
+
+		// A resizing warehouse keeps running while it changes size
+		running := 0.0
+		switch strings.ToUpper(values[stateIndex].String) {
+		case "STARTED", "RESIZING":
+			running = 1
+		}
+		runningCount += running
+		ch <- prometheus.MustNewConstMetric(
+			c.warehouseRunning,
+			prometheus.GaugeValue,
+			running,
+			values[nameIndex].String,
+			values[sizeIndex].String,
+		)
+
+		// A NULL or zero auto_suspend means the warehouse never suspends. An
+		// unparsable value drops only this warehouse's auto_suspend sample.
+		if autoSuspendIndex >= 0 {
+			autoSuspend := 0.0
+			var parseErr error
+			if v := values[autoSuspendIndex]; v.Valid && v.String != "" {
+				autoSuspend, parseErr = strconv.ParseFloat(v.String, 64)
+			}
+			if parseErr != nil {
+				c.logger.Error("Error parsing warehouse auto_suspend", "warehouse", values[nameIndex].String, "err", parseErr)
+				c.scrapeErrors.WithLabelValues("warehouse_state").Inc()
+			} else {
+				ch <- prometheus.MustNewConstMetric(
+					c.warehouseAutoSuspend,
+					prometheus.GaugeValue,
+					autoSuspend,
+					values[nameIndex].String,
+				)
+			}
+		}
+		if autoResumeIndex >= 0 {
+			autoResume := 0.0
+			if strings.EqualFold(values[autoResumeIndex].String, "true") {
+				autoResume = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.warehouseAutoResume,
+				prometheus.GaugeValue,
+				autoResume,
+				values[nameIndex].String,
+			)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(
+		c.runningWarehouses,
+		prometheus.GaugeValue,
+		runningCount,
+	)
+	return nil
+}
+
+// collectUserCredits emits an estimate of the warehouse credits each user
+// consumed over the lookback window. Snowflake meters credits per warehouse
+// and hour, not per query, so each hour's compute credits are split between
+// the users who ran queries on that warehouse in proportion to their share of
+// its execution time. Idle time is attributed to no one, so the per-user
+// figures add up to less than the warehouse credits.
+func (c *SnowflakeMetricsCollector) collectUserCredits(ctx context.Context, ch chan<- prometheus.Metric) error {
+	userCreditsQuery := fmt.Sprintf(`
+		WITH user_time AS (
+			SELECT warehouse_name, user_name, DATE_TRUNC('hour', start_time) as hour, SUM(execution_time) as execution_ms 
+			FROM snowflake.account_usage.query_history 
+			WHERE start_time > %s AND warehouse_name IS NOT NULL 
+			GROUP BY warehouse_name, user_name, hour
+		), warehouse_time AS (
+			SELECT warehouse_name, hour, SUM(execution_ms) as execution_ms 
+			FROM user_time 
+			GROUP BY warehouse_name, hour
+		)
+		SELECT u.user_name, SUM(m.credits_used_compute * u.execution_ms / NULLIF(w.execution_ms, 0)) as estimated_credits 
+		FROM user_time u 
+		JOIN warehouse_time w ON w.warehouse_name = u.warehouse_name AND w.hour = u.hour 
+		JOIN snowflake.account_usage.warehouse_metering_history m ON m.warehouse_name = u.warehouse_name AND m.start_time = u.hour 
+		GROUP BY u.user_name
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "user_credits", userCreditsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userName sql.NullString
+		var credits sql.NullFloat64
+		if err := rows.Scan(&userName, &credits); err != nil {
+			c.logger.Error("Error scanning user credits", "err", err)
+			c.scrapeErrors.WithLabelValues("user_credits").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !userName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "user_credits")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.userCredits,
+			prometheus.GaugeValue,
+			credits.Float64,
+			userName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectStorageBreakdown emits the active, Time Travel and Fail-safe storage
+// of each database. database_storage_usage_history does not separate Time
+// Travel from active bytes, so the figures are summed from the per-table
+// metrics instead. Dropped tables are included, as their Time Travel and
+// Fail-safe bytes are still billed.
+func (c *SnowflakeMetricsCollector) collectStorageBreakdown(ctx context.Context, ch chan<- prometheus.Metric) error {
+	storageBreakdownQuery := `
+		SELECT table_catalog as database_name, SUM(active_bytes) as active_bytes, SUM(time_travel_bytes) as time_travel_bytes, SUM(failsafe_bytes) as failsafe_bytes 
+		FROM snowflake.account_usage.table_storage_metrics 
+		GROUP BY table_catalog
+	`
+	rows, err := c.query(ctx, "storage_breakdown", storageBreakdownQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var databaseName sql.NullString
+		var activeBytes, timeTravelBytes, failsafeBytes sql.NullFloat64
+		if err := rows.Scan(&databaseName, &activeBytes, &timeTravelBytes, &failsafeBytes); err != nil {
+			c.logger.Error("Error scanning storage breakdown", "err", err)
+			c.scrapeErrors.WithLabelValues("storage_breakdown").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !databaseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "storage_breakdown")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.activeBytes,
+			prometheus.GaugeValue,
+			activeBytes.Float64,
+			databaseName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.timeTravelBytes,
+			prometheus.GaugeValue,
+			timeTravelBytes.Float64,
+			databaseName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.failsafeBytes,
+			prometheus.GaugeValue,
+			failsafeBytes.Float64,
+			databaseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectStageStorage emits the bytes stored in the account's internal stages
+// today. The view has a single account-wide row per day, so no series is
+// emitted until Snowflake has recorded today's row.
+func (c *SnowflakeMetricsCollector) collectStageStorage(ctx context.Context, ch chan<- prometheus.Metric) error {
+	stageStorageQuery := fmt.Sprintf(`
+		SELECT SUM(average_stage_bytes) as stage_bytes 
+		FROM %s 
+		WHERE usage_date = current_date()
+	`, c.usageView("stage_storage_usage_history"))
+	rows, err := c.query(ctx, "stage_storage", stageStorageQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stageBytes sql.NullFloat64
+		if err := rows.Scan(&stageBytes); err != nil {
+			c.logger.Error("Error scanning stage storage", "err", err)
+			c.scrapeErrors.WithLabelValues("stage_storage").Inc()
+			continue
+		}
+		// The sum is NULL when there is no row for today yet
+		if !stageBytes.Valid {
+			c.logger.Debug("Skipping row with NULL value", "query", "stage_storage")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.stageBytes,
+			prometheus.GaugeValue,
+			stageBytes.Float64,
+		)
+	}
+	return rows.Err()
+}
+
+// collectBytesSpilled emits the bytes queries spilled to local and remote
+// storage per warehouse over the lookback window. Remote spilling is a sign
+// of an undersized warehouse.
+func (c *SnowflakeMetricsCollector) collectBytesSpilled(ctx context.Context, ch chan<- prometheus.Metric) error {
+	bytesSpilledQuery := fmt.Sprintf(`
+		SELECT warehouse_name, SUM(bytes_spilled_to_local_storage) as bytes_spilled_local, SUM(bytes_spilled_to_remote_storage) as bytes_spilled_remote 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s AND warehouse_name IS NOT NULL 
+		GROUP BY warehouse_name
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "bytes_spilled", bytesSpilledQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var localBytes, remoteBytes sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &localBytes, &remoteBytes); err != nil {
+			c.logger.Error("Error scanning bytes spilled", "err", err)
+			c.scrapeErrors.WithLabelValues("bytes_spilled").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "bytes_spilled")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.bytesSpilledLocal,
+			prometheus.GaugeValue,
+			localBytes.Float64,
+			warehouseName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.bytesSpilledRemote,
+			prometheus.GaugeValue,
+			remoteBytes.Float64,
+			warehouseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectSnowflakeVersion emits the Snowflake release the account is running.
+func (c *SnowflakeMetricsCollector) collectSnowflakeVersion(ctx context.Context, ch chan<- prometheus.Metric) error {
+	rows, err := c.query(ctx, "snowflake_version", "SELECT CURRENT_VERSION()")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var snowflakeVersion sql.NullString
+		if err := rows.Scan(&snowflakeVersion); err != nil {
+			c.logger.Error("Error scanning Snowflake version", "err", err)
+			c.scrapeErrors.WithLabelValues("snowflake_version").Inc()
+			continue
+		}
+		if !snowflakeVersion.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "snowflake_version")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.versionInfo,
+			prometheus.GaugeValue,
+			1,
+			snowflakeVersion.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectTableStorage emits the active storage of the largest tables. A series
+// per table can run to many thousands in a large account, so the group is
+// opt-in and only the tableStorageLimit largest tables are exported; raise
+// the limit with care.
+func (c *SnowflakeMetricsCollector) collectTableStorage(ctx context.Context, ch chan<- prometheus.Metric) error {
+	tableStorageQuery := `
+		SELECT table_catalog, table_schema, table_name, active_bytes 
+		FROM snowflake.account_usage.table_storage_metrics 
+		WHERE deleted = FALSE 
+		ORDER BY active_bytes DESC 
+		LIMIT ?
+	`
+	rows, err := c.query(ctx, "table_storage", tableStorageQuery, c.tableStorageLimit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var databaseName, schemaName, tableName sql.NullString
+		var activeBytes sql.NullFloat64
+		if err := rows.Scan(&databaseName, &schemaName, &tableName, &activeBytes); err != nil {
+			c.logger.Error("Error scanning table storage", "err", err)
+			c.scrapeErrors.WithLabelValues("table_storage").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !databaseName.Valid || !schemaName.Valid || !tableName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "table_storage")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.tableBytes,
+			prometheus.GaugeValue,
+			activeBytes.Float64,
+			databaseName.String,
+			schemaName.String,
+			tableName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectFailedQueries emits the number of failed queries per warehouse and
+// error code over the lookback window. Failures without an error code are
+// reported under an empty error_code.
+func (c *SnowflakeMetricsCollector) collectFailedQueries(ctx context.Context, ch chan<- prometheus.Metric) error {
+	failedQueriesQuery := fmt.Sprintf(`
+		SELECT warehouse_name, error_code, COUNT(*) as failed_queries 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s AND execution_status = 'FAIL' AND warehouse_name IS NOT NULL 
+		GROUP BY warehouse_name, error_code
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "failed_queries", failedQueriesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName, errorCode sql.NullString
+		var failedQueries sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &errorCode, &failedQueries); err != nil {
+			c.logger.Error("Error scanning failed queries", "err", err)
+			c.scrapeErrors.WithLabelValues("failed_queries").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "failed_queries")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.failedQueries,
+			prometheus.GaugeValue,
+			failedQueries.Float64,
+			warehouseName.String,
+			errorCode.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectWarehouseLoad emits the average number of running and queued queries
+// per warehouse over the lookback window. The view holds one sample per five
+// minute interval, which are averaged in SQL; queued covers both queuing for
+// load and for provisioning.
+func (c *SnowflakeMetricsCollector) collectWarehouseLoad(ctx context.Context, ch chan<- prometheus.Metric) error {
+	warehouseLoadQuery := fmt.Sprintf(`
+		SELECT warehouse_name, AVG(avg_running) as avg_running, AVG(avg_queued_load + avg_queued_provisioning) as avg_queued 
+		FROM snowflake.account_usage.warehouse_load_history 
+		WHERE start_time > %s 
+		GROUP BY warehouse_name
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "warehouse_load", warehouseLoadQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var avgRunning, avgQueued sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &avgRunning, &avgQueued); err != nil {
+			c.logger.Error("Error scanning warehouse load", "err", err)
+			c.scrapeErrors.WithLabelValues("warehouse_load").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "warehouse_load")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.warehouseAvgRunning,
+			prometheus.GaugeValue,
+			avgRunning.Float64,
+			warehouseName.String,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.warehouseAvgQueued,
+			prometheus.GaugeValue,
+			avgQueued.Float64,
+			warehouseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectActiveUsers emits the number of distinct users that ran queries
+// over the lookback window.
+func (c *SnowflakeMetricsCollector) collectActiveUsers(ctx context.Context, ch chan<- prometheus.Metric) error {
+	activeUsersQuery := fmt.Sprintf(`
+		SELECT COUNT(DISTINCT user_name) as active_users 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "active_users", activeUsersQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var activeUsers float64
+		if err := rows.Scan(&activeUsers); err != nil {
+			c.logger.Error("Error scanning active users", "err", err)
+			c.scrapeErrors.WithLabelValues("active_users").Inc()
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.activeUsers,
+			prometheus.GaugeValue,
+			activeUsers,
+		)
+	}
+	return rows.Err()
+}
+
+// queryStatuses are the execution statuses query_history reports. Any other
+// value is counted as OTHER so that a new or malformed status cannot add
+// series unnoticed.
+var queryStatuses = map[string]bool{
+	"SUCCESS":  true,
+	"FAIL":     true,
+	"INCIDENT": true,
+}
+
+// collectQueriesByStatus emits the number of queries per warehouse and
+// execution status over the lookback window.
+func (c *SnowflakeMetricsCollector) collectQueriesByStatus(ctx context.Context, ch chan<- prometheus.Metric) error {
+	queriesByStatusQuery := fmt.Sprintf(`
+		SELECT warehouse_name, execution_status, COUNT(*) as query_count 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s 
+		GROUP BY warehouse_name, execution_status
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "queries_by_status", queriesByStatusQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Unexpected statuses are folded into OTHER, so sum before emitting
+	type key struct{ warehouse, status string }
+	counts := map[key]float64{}
+	for rows.Next() {
+		var warehouseName, status sql.NullString
+		var queryCount float64
+		if err := rows.Scan(&warehouseName, &status, &queryCount); err != nil {
+			c.logger.Error("Error scanning queries by status", "err", err)
+			c.scrapeErrors.WithLabelValues("queries_by_status").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "queries_by_status")
+			continue
+		}
+		s := strings.ToUpper(status.String)
+		if !queryStatuses[s] {
+			c.logger.Debug("Counting unexpected execution status as OTHER", "status", status.String)
+			s = "OTHER"
+		}
+		counts[key{warehouseName.String, s}] += queryCount
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			c.queriesByStatus,
+			prometheus.GaugeValue,
+			count,
+			k.warehouse,
+			k.status,
+		)
+	}
+	return nil
+}
+
+// collectLoginsByClientIP emits the number of successful logins per user and
+// client IP over the lookback window, so logins from unusual places stand
+// out. login_history carries no region or country, so the raw client IP is
+// exported and can be mapped to a location downstream. A series per user and
+// address can be numerous, so the group is opt-in.
+func (c *SnowflakeMetricsCollector) collectLoginsByClientIP(ctx context.Context, ch chan<- prometheus.Metric) error {
+	loginsByClientIPQuery := fmt.Sprintf(`
+		SELECT user_name, client_ip, COUNT(*) as logins 
+		FROM snowflake.account_usage.login_history 
+		WHERE is_success = 'YES' AND event_timestamp > %s 
+		GROUP BY user_name, client_ip
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "logins_by_client_ip", loginsByClientIPQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userName, clientIP sql.NullString
+		var logins float64
+		if err := rows.Scan(&userName, &clientIP, &logins); err != nil {
+			c.logger.Error("Error scanning logins by client IP", "err", err)
+			c.scrapeErrors.WithLabelValues("logins_by_client_ip").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !userName.Valid || !clientIP.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "logins_by_client_ip")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.loginsByClientIP,
+			prometheus.GaugeValue,
+			logins,
+			userName.String,
+			clientIP.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectRemainingBalance emits the capacity balance left on the
+// organization's contract as of the latest day in remaining_balance_daily.
+// Only accounts with a capacity contract and a role granted organization
+// usage can read the view, so the group is opt-in and a role without access
+// yields no samples instead of failing the scrape.
+func (c *SnowflakeMetricsCollector) collectRemainingBalance(ctx context.Context, ch chan<- prometheus.Metric) error {
+	remainingBalanceQuery := `
+		SELECT currency, capacity_balance 
+		FROM snowflake.organization_usage.remaining_balance_daily 
+		WHERE date = (SELECT MAX(date) FROM snowflake.organization_usage.remaining_balance_daily)
+	`
+	rows, err := c.query(ctx, "remaining_balance", remainingBalanceQuery)
+	if isPermissionError(err) {
+		c.logger.Debug("Skipping remaining balance without access to organization usage", "err", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var currency sql.NullString
+		var balance sql.NullFloat64
+		if err := rows.Scan(&currency, &balance); err != nil {
+			c.logger.Error("Error scanning remaining balance", "err", err)
+			c.scrapeErrors.WithLabelValues("remaining_balance").Inc()
+			continue
+		}
+		// Days without a capacity contract report no balance
+		if !balance.Valid {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.remainingBalance,
+			prometheus.GaugeValue,
+			balance.Float64,
+			currency.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectDailyCredits emits the credits used so far today per service type,
+// such as WAREHOUSE_METERING or PIPE, from the daily metering rollup. The
+// current day is used rather than the lookback window so the series reads
+// as today's spend by service.
+func (c *SnowflakeMetricsCollector) collectDailyCredits(ctx context.Context, ch chan<- prometheus.Metric) error {
+	dailyCreditsQuery := fmt.Sprintf(`
+		SELECT service_type, SUM(credits_used) as credits_used 
+		FROM %s 
+		WHERE usage_date = CURRENT_DATE() 
+		GROUP BY service_type
+	`, c.usageView("metering_daily_history"))
+	rows, err := c.query(ctx, "daily_credits", dailyCreditsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var serviceType sql.NullString
+		var creditsUsed sql.NullFloat64
+		if err := rows.Scan(&serviceType, &creditsUsed); err != nil {
+			c.logger.Error("Error scanning daily credits", "err", err)
+			c.scrapeErrors.WithLabelValues("daily_credits").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !serviceType.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "daily_credits")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.dailyCredits,
+			prometheus.GaugeValue,
+			creditsUsed.Float64,
+			serviceType.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectClusteringDepth emits the average clustering depth of each
+// configured table, computed by SYSTEM$CLUSTERING_DEPTH with one query per
+// table. A table that cannot be measured, for example because it has no
+// clustering key, is logged and fails the group with the first such error
+// once the other tables have been measured.
+func (c *SnowflakeMetricsCollector) collectClusteringDepth(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var failed error
+	for _, table := range c.clusteringTables {
+		depth, err := c.tableClusteringDepth(ctx, table)
+		if err != nil {
+			c.logger.Error("Error fetching clustering depth", "table", table, "err", err)
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		parts := strings.SplitN(table, ".", 3)
+		ch <- prometheus.MustNewConstMetric(
+			c.clusteringDepth,
+			prometheus.GaugeValue,
+			depth,
+			parts[0],
+			parts[1],
+			parts[2],
+		)
+	}
+	return failed
+}
+
+// tableClusteringDepth returns the clustering depth of the fully qualified table.
+func (c *SnowflakeMetricsCollector) tableClusteringDepth(ctx context.Context, table string) (float64, error) {
+	rows, err := c.query(ctx, "clustering_depth", "SELECT SYSTEM$CLUSTERING_DEPTH(?) as clustering_depth", table)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var depth float64
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("no clustering depth returned")
+	}
+	if err := rows.Scan(&depth); err != nil {
+		return 0, err
+	}
+	return depth, rows.Err()
+}
+
+// collectQueryCountByDatabase emits the number of queries run against each
+// database over the lookback window. Queries without a database context,
+// such as SHOW or USE statements, have a NULL database and are reported with
+// an empty database_name label.
+func (c *SnowflakeMetricsCollector) collectQueryCountByDatabase(ctx context.Context, ch chan<- prometheus.Metric) error {
+	queryCountByDatabaseQuery := fmt.Sprintf(`
+		SELECT database_name, COUNT(*) as query_count 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s 
+		GROUP BY database_name
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "query_count_by_database", queryCountByDatabaseQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var databaseName sql.NullString
+		var queryCount float64
+		if err := rows.Scan(&databaseName, &queryCount); err != nil {
+			c.logger.Error("Error scanning query count by database", "err", err)
+			c.scrapeErrors.WithLabelValues("query_count_by_database").Inc()
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.queryCountByDatabase,
+			prometheus.GaugeValue,
+			queryCount,
+			databaseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// collectCompilationTime emits the average time queries spent compiling per
+// warehouse over the lookback window. A high average points at metadata or
+// parsing overhead rather than execution. Queries without a compilation time
+// are left out of the average, and warehouses with none at all are skipped.
+func (c *SnowflakeMetricsCollector) collectCompilationTime(ctx context.Context, ch chan<- prometheus.Metric) error {
+	compilationTimeQuery := fmt.Sprintf(`
+		SELECT warehouse_name, AVG(compilation_time) as avg_compilation_ms 
+		FROM snowflake.account_usage.query_history 
+		WHERE start_time > %s AND warehouse_name IS NOT NULL 
+		GROUP BY warehouse_name
+	`, lookbackStart(c.lookback))
+	rows, err := c.query(ctx, "compilation_time", compilationTimeQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var warehouseName sql.NullString
+		var compilationMs sql.NullFloat64
+		if err := rows.Scan(&warehouseName, &compilationMs); err != nil {
+			c.logger.Error("Error scanning compilation time", "err", err)
+			c.scrapeErrors.WithLabelValues("compilation_time").Inc()
+			continue
+		}
+		// Rows without a name cannot be labeled
+		if !warehouseName.Valid {
+			c.logger.Debug("Skipping row with NULL name", "query", "compilation_time")
+			continue
+		}
+		if !compilationMs.Valid {
+			c.logger.Debug("Skipping row with NULL value", "query", "compilation_time")
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.compilationTime,
+			prometheus.GaugeValue,
+			millisecondsToSeconds(compilationMs.Float64),
+			warehouseName.String,
+		)
+	}
+	return rows.Err()
+}
+
+// usageView returns the qualified name of the usage view in the configured
+// usage schema. Only queries that aggregate views organization_usage also
+// provides, with the same columns, read through it, so rows of several
+// accounts add up instead of repeating a series.
+func (c *SnowflakeMetricsCollector) usageView(view string) string {
+	return "snowflake." + c.usageSchema + "." + view
+}
+
+// lookbackStart returns the SQL expression for the start of the lookback
+// window, using the largest date part that represents it exactly.
+func lookbackStart(lookback time.Duration) string {
+	seconds := int64(lookback / time.Second)
+	switch {
+	case seconds%86400 == 0:
+		return fmt.Sprintf("dateadd(day, -%d, current_timestamp())", seconds/86400)
+	case seconds%3600 == 0:
+		return fmt.Sprintf("dateadd(hour, -%d, current_timestamp())", seconds/3600)
+	case seconds%60 == 0:
+		return fmt.Sprintf("dateadd(minute, -%d, current_timestamp())", seconds/60)
+	default:
+		return fmt.Sprintf("dateadd(second, -%d, current_timestamp())", seconds)
+	}
+}
+
+// warehouseFilterClause returns an AND condition restricting warehouse_name
+// to warehouses, with one bind placeholder per name, and the matching query
+// arguments. It returns an empty clause when warehouses is empty.
+func warehouseFilterClause(warehouses []string) (string, []interface{}) {
+	if len(warehouses) == 0 {
+		return "", nil
+	}
+
+	placeholders := make([]string, len(warehouses))
+	args := make([]interface{}, len(warehouses))
+	for i, name := range warehouses {
+		placeholders[i] = "?"
+		args[i] = name
+	}
+	return fmt.Sprintf(" AND warehouse_name IN (%s)", strings.Join(placeholders, ", ")), args
+}
+
+func main() {
+	configFile := flag.String("config.file", "", "Path to a YAML configuration file.")
+	validate := flag.Bool("validate", false, "Run every query once, print the results and exit.")
+	dump := flag.Bool("dump", false, "Scrape once, write the metrics in the Prometheus text format and exit.")
+	dumpFile := flag.String("dump.file", "", "File -dump writes to instead of stdout.")
+	list := flag.Bool("list-metrics", false, "Print the metric groups, their metrics and the views they read under the configured usage_schema, and exit.")
+	flag.Parse()
+
+	cfg, err := LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if *list {
+		if err := listMetrics(os.Stdout, cfg.UsageSchema); err != nil {
+			log.Fatalf("Failed to list metrics: %v", err)
+		}
+		return
+	}
+
+	logOutput := io.Writer(os.Stderr)
+	var file *logFile
+	if cfg.LogFile != "" {
+		if file, err = openLogFile(cfg.LogFile); err != nil {
+			log.Fatalf("Failed to set up logging: %v", err)
+		}
+		defer file.Close()
+		logOutput = file
+	}
+	logger, err := newLogger(logOutput, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	for _, cc := range cfg.accounts() {
+		if err := validateConfig(cc); err != nil {
+			slog.Error("Invalid configuration", "account", cc.Account, "err", err)
+			os.Exit(1)
+		}
+	}
+	if err := cfg.Proxy.apply(); err != nil {
+		slog.Error("Failed to configure proxy", "err", err)
+		os.Exit(1)
+	}
+
+	if *validate {
+		if err := runValidate(*cfg); err != nil {
+			slog.Error("Validation failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *dump {
+		if err := runDump(*cfg, *dumpFile); err != nil {
+			slog.Error("Dump failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// On SIGHUP reopen the log file, so it can be rotated, and reload the
+	// configuration file
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reloads := make(chan Config)
+	go func() {
+		for range hup {
+			if file != nil {
+				if err := file.Reopen(); err != nil {
+					slog.Error("Failed to reopen log file", "path", cfg.LogFile, "err", err)
+				}
+			}
+			next, err := LoadConfig(*configFile)
+			if err != nil {
+				slog.Error("Failed to reload configuration, keeping the current one", "err", err)
+				continue
+			}
+			select {
+			case reloads <- *next:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := run(ctx, *cfg, reloads); err != nil {
+		slog.Error("Exporter failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// run serves metrics until ctx is cancelled, then shuts the HTTP server down
+// and closes the Snowflake connections.
+func run(ctx context.Context, cfg Config, reloads <-chan Config) error {
+	var collectors []*SnowflakeMetricsCollector
+	defer func() {
+		for _, collector := range collectors {
+			collector.Close()
+		}
+	}()
+
+	// Background refreshers stop with run, however it returns, and are
+	// waited for so an in-progress refresh finishes before the connections
+	// are closed
+	ctx, cancel := context.WithCancel(ctx)
+	var refreshers []chan struct{}
+	defer func() {
+		cancel()
+		for _, done := range refreshers {
+			<-done
+		}
+	}()
+
+	// Build info describes the exporter rather than an account, so it only
+	// carries the extra labels
+	registry := newRegistry(cfg)
+	if err := labeledRegisterer(registry, cfg.ExtraLabels).Register(newBuildInfo()); err != nil {
+		return fmt.Errorf("failed to register build info: %v", err)
+	}
+
+	// One collector per account, each adding the extra labels and, unless
+	// disabled, its account to every metric
+	accounts := cfg.accounts()
+	var regs []registration
+	described := prometheus.NewRegistry()
+	for _, cc := range accounts {
+		collector, err := newAccountCollector(cfg, cc)
+		if err != nil {
+			return fmt.Errorf("account %s: %v", cc.Account, err)
+		}
+		collectors = append(collectors, collector)
+
+		// Requests are served from a registry of their own, see
+		// metricsHandler, so registering here only reports conflicting
+		// metrics at startup
+		labels := cfg.metricLabels(cc.Account)
+		if err := labeledRegisterer(described, labels).Register(collector); err != nil {
+			return fmt.Errorf("failed to register collector for account %s: %v", cc.Account, err)
+		}
+		regs = append(regs, registration{collector, labels})
+
+		// Refresh in the background when configured
+		if collector.refreshInterval > 0 {
+			refresherDone := make(chan struct{})
+			go func() {
+				collector.runRefresher(ctx)
+				close(refresherDone)
+			}()
+			refreshers = append(refreshers, refresherDone)
+		}
+	}
+
+	go watchReloads(ctx, cfg, regs, reloads)
+
+	// Expose metrics and probe endpoints. Queries run under the context of
+	// the metrics request, so a scrape Prometheus gives up on stops too.
+	pingers := make([]pinger, len(collectors))
+	for i, collector := range collectors {
+		pingers[i] = collector
+	}
+	metrics := metricsHandler(registry, registry, regs, cfg.MetricsGzip)
+	server := newServer(cfg, newHandler(cfg, metrics, pingers...))
+
+	// Start server
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", server.Addr, err)
+	}
+	slog.Info("Starting Snowflake Prometheus Exporter", "version", version, "address", server.Addr, "tls", cfg.TLSCertFile != "", "accounts", len(accounts))
+	return serve(ctx, server, listener, cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// labeledRegisterer returns a registerer that adds labels to every metric
+// registered through it, or reg itself when there are no labels.
+func labeledRegisterer(reg prometheus.Registerer, labels prometheus.Labels) prometheus.Registerer {
+	if len(labels) == 0 {
+		return reg
+	}
+	return prometheus.WrapRegistererWith(labels, reg)
+}
+
+// newAccountCollector connects to the account described by cc and returns a
+// collector configured from the exporter-wide settings in cfg.
+func newAccountCollector(cfg Config, cc connectionConfig) (*SnowflakeMetricsCollector, error) {
+	if cc.InsecureMode {
+		slog.Warn("Insecure mode is enabled: OCSP certificate revocation checks are disabled, do not use it in production", "account", cc.Account)
+	}
+
+	// Snowflake connection parameters
+	dsn, err := buildDSN(cc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Snowflake connection: %v", err)
+	}
+
+	// Create Snowflake metrics collector
+	collector, err := NewSnowflakeMetricsCollector(dsn, cfg.Pool, cc.sessionStatements()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Snowflake metrics collector: %v", err)
+	}
+	collector.refreshInterval = cfg.RefreshInterval
+	collector.configure(cfg)
+	return collector, nil
+}
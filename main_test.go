@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"net"
+	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/snowflakedb/gosnowflake"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
@@ -28,21 +35,22 @@ func (m *MockDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
 
 func TestSnowflakeMetricsCollector_Collect(t *testing.T) {
 	// Create a sqlmock database
-	db, mock, err := sqlmock.Open()
+	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	// Prepare mock rows for warehouse credits
-	warehouseCreditRows := sqlmock.NewRows([]string{"warehouse_name", "total_credits"}).
-		AddRow("COMPUTE_WH", 10.5).
-		AddRow("REPORTING_WH", 5.2)
+	warehouseCreditRows := sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+		AddRow("COMPUTE_WH", 10.5, nil, nil, nil).
+		AddRow("REPORTING_WH", 5.2, nil, nil, nil)
 
 	// Prepare mock rows for storage bytes
 	storageRows := sqlmock.NewRows([]string{"database_name", "storage_bytes"}).
 		AddRow("PROD_DB", 1024000).
 		AddRow("DEV_DB", 512000)
 
-	// Expect queries
+	// Expect queries; groups run concurrently, so in any order
+	mock.MatchExpectationsInOrder(false)
 	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
 		WillReturnRows(warehouseCreditRows)
 
@@ -50,55 +58,96 @@ func TestSnowflakeMetricsCollector_Collect(t *testing.T) {
 		WillReturnRows(storageRows)
 
 	// Create collector with mock DB
-	collector := &SnowflakeMetricsCollector{
-		db: db,
-		wareouseCredits: prometheus.NewDesc(
-			"snowflake_warehouse_credits_used",
-			"Number of credits used by warehouse",
-			[]string{"warehouse_name"},
-			nil,
-		),
-		storageBytes: prometheus.NewDesc(
-			"snowflake_storage_bytes",
-			"Total storage used in bytes",
-			[]string{"database_name"},
-			nil,
-		),
-	}
-
-	// Collect metrics
-	ch := make(chan prometheus.Metric, 10)
-	collector.Collect(ch)
-	close(ch)
+	collector := newSnowflakeMetricsCollector(db)
 
-	// Validate warehouse credits metrics
-	expectedWarehouseCredits := `
+	// Validate warehouse credits and storage bytes metrics
+	expected := `
 		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
 		# TYPE snowflake_warehouse_credits_used gauge
-		snowflake_warehouse_credits_used{warehouse_name="COMPUTE_WH"} 10.5
-		snowflake_warehouse_credits_used{warehouse_name="REPORTING_WH"} 5.2
-	`
-	err = testutil.CollectAndCompare(collector,
-		strings.NewReader(expectedWarehouseCredits),
-		"snowflake_warehouse_credits_used")
-	assert.NoError(t, err)
-
-	// Validate storage bytes metrics
-	expectedStorageBytes := `
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 10.5
+		snowflake_warehouse_credits_used{size="",warehouse_name="REPORTING_WH"} 5.2
 		# HELP snowflake_storage_bytes Total storage used in bytes
 		# TYPE snowflake_storage_bytes gauge
 		snowflake_storage_bytes{database_name="PROD_DB"} 1024000
 		snowflake_storage_bytes{database_name="DEV_DB"} 512000
 	`
 	err = testutil.CollectAndCompare(collector,
-		strings.NewReader(expectedStorageBytes),
-		"snowflake_storage_bytes")
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_storage_bytes")
+	assert.NoError(t, err)
+}
+
+func TestSnowflakeMetricsCollector_WarehouseCreditsBreakdown(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits,\\s+SUM\\(credits_used_compute\\) as compute_credits,\\s+SUM\\(credits_used_cloud_services\\) as cloud_services_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, 10, 0.5, nil).
+			AddRow("REPORTING_WH", 5.2, 5, 0.2, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	expected := `
+		# HELP snowflake_warehouse_credits_cloud_services Number of cloud services credits used by warehouse
+		# TYPE snowflake_warehouse_credits_cloud_services gauge
+		snowflake_warehouse_credits_cloud_services{warehouse_name="COMPUTE_WH"} 0.5
+		snowflake_warehouse_credits_cloud_services{warehouse_name="REPORTING_WH"} 0.2
+		# HELP snowflake_warehouse_credits_compute Number of compute credits used by warehouse
+		# TYPE snowflake_warehouse_credits_compute gauge
+		snowflake_warehouse_credits_compute{warehouse_name="COMPUTE_WH"} 10
+		snowflake_warehouse_credits_compute{warehouse_name="REPORTING_WH"} 5
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 10.5
+		snowflake_warehouse_credits_used{size="",warehouse_name="REPORTING_WH"} 5.2
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used",
+		"snowflake_warehouse_credits_compute",
+		"snowflake_warehouse_credits_cloud_services")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_WarehouseCreditsSize(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The size comes from each warehouse's latest query in the window
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits, .* ANY_VALUE\\(warehouse_size\\) as warehouse_size .*" +
+		"QUALIFY ROW_NUMBER\\(\\) OVER \\(PARTITION BY warehouse_name ORDER BY start_time DESC\\) = 1").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, 10, 0.5, "Large").
+			AddRow("REPORTING_WH", 5.2, 5, 0.2, "X-Small").
+			AddRow("IDLE_WH", 0.1, 0.1, 0, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	expected := `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="IDLE_WH"} 0.1
+		snowflake_warehouse_credits_used{size="Large",warehouse_name="COMPUTE_WH"} 10.5
+		snowflake_warehouse_credits_used{size="X-Small",warehouse_name="REPORTING_WH"} 5.2
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used")
 	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestSnowflakeMetricsCollector_QueryError(t *testing.T) {
 	// Create a sqlmock database
-	db, mock, err := sqlmock.Open()
+	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
@@ -107,50 +156,40 @@ func TestSnowflakeMetricsCollector_QueryError(t *testing.T) {
 		WillReturnError(fmt.Errorf("database connection error"))
 
 	// Create collector with mock DB
-	collector := &SnowflakeMetricsCollector{
-		db: db,
-		wareouseCredits: prometheus.NewDesc(
-			"snowflake_warehouse_credits_used",
-			"Number of credits used by warehouse",
-			[]string{"warehouse_name"},
-			nil,
-		),
-	}
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
 
 	// Collect metrics (should handle error gracefully)
-	ch := make(chan prometheus.Metric, 10)
+	ch := make(chan prometheus.Metric, 100)
 	collector.Collect(ch)
 	close(ch)
 
-	// Verify no metrics were sent
-	assert.Equal(t, 0, len(ch))
+	// Verify no warehouse credits were sent, only the exporter's own metrics
+	var names []string
+	for metric := range ch {
+		names = append(names, metric.Desc().String())
+	}
+	// The last success timestamp and pool gauges are followed by one error
+	// counter series per query and the reconnect counter
+	assert.Equal(t, 8+len(metricGroupNames()), len(names))
+	assert.Contains(t, names[0], "snowflake_lookback_window_seconds")
+	assert.Contains(t, names[1], "snowflake_scrape_query_duration_seconds")
+	assert.Contains(t, names[2], "snowflake_up")
+	assert.Contains(t, names[3], "snowflake_scrape_duration_seconds")
+	assert.Contains(t, names[4], "snowflake_last_scrape_success_timestamp_seconds")
 }
 
 func TestSnowflakeMetricsCollector_Describe(t *testing.T) {
 	// Create a mock database (not used in Describe)
-	db, _, err := sqlmock.Open()
+	db, _, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	// Create collector
-	collector := &SnowflakeMetricsCollector{
-		db: db,
-		wareouseCredits: prometheus.NewDesc(
-			"snowflake_warehouse_credits_used",
-			"Number of credits used by warehouse",
-			[]string{"warehouse_name"},
-			nil,
-		),
-		storageBytes: prometheus.NewDesc(
-			"snowflake_storage_bytes",
-			"Total storage used in bytes",
-			[]string{"database_name"},
-			nil,
-		),
-	}
+	collector := newSnowflakeMetricsCollector(db)
 
 	// Channel to receive descriptions
-	ch := make(chan *prometheus.Desc, 10)
+	ch := make(chan *prometheus.Desc, 100)
 	collector.Describe(ch)
 	close(ch)
 
@@ -161,7 +200,2662 @@ func TestSnowflakeMetricsCollector_Describe(t *testing.T) {
 	}
 
 	// Verify correct number of descriptions
-	assert.Equal(t, 2, len(descriptions))
-	assert.Contains(t, descriptions[0], "snowflake_warehouse_credits_used")
-	assert.Contains(t, descriptions[1], "snowflake_storage_bytes")
+	expected := []string{
+		"snowflake_warehouse_credits_used",
+		"snowflake_warehouse_credits_compute",
+		"snowflake_warehouse_credits_cloud_services",
+		"snowflake_storage_bytes",
+		"snowflake_query_count",
+		"snowflake_concurrent_queries",
+		"snowflake_failed_logins_total",
+		"snowflake_logins_total",
+		"snowflake_query_execution_time_seconds",
+		"snowflake_bytes_scanned_total",
+		"snowflake_data_transfer_bytes_total",
+		"snowflake_automatic_clustering_credits_used",
+		"snowflake_materialized_view_credits_used",
+		"snowflake_pipe_credits_used",
+		"snowflake_pipe_bytes_inserted_total",
+		"snowflake_task_runs_total",
+		"snowflake_task_failures_total",
+		"snowflake_replication_credits_used",
+		"snowflake_replication_bytes_transferred_total",
+		"snowflake_serverless_task_credits_used",
+		"snowflake_query_queued_provisioning_seconds",
+		"snowflake_query_queued_overload_seconds",
+		"snowflake_warehouse_running",
+		"snowflake_running_warehouses_total",
+		"snowflake_warehouse_auto_suspend_seconds",
+		"snowflake_warehouse_auto_resume",
+		"snowflake_user_credits_used",
+		"snowflake_active_bytes",
+		"snowflake_time_travel_bytes",
+		"snowflake_failsafe_bytes",
+		"snowflake_stage_bytes",
+		"snowflake_bytes_spilled_local_total",
+		"snowflake_bytes_spilled_remote_total",
+		"snowflake_version_info",
+		"snowflake_failed_queries_total",
+		"snowflake_warehouse_avg_running",
+		"snowflake_warehouse_avg_queued",
+		"snowflake_active_users",
+		"snowflake_queries_by_status",
+		"snowflake_daily_credits_used",
+		"snowflake_query_count_by_database",
+		"snowflake_query_compilation_time_seconds",
+		"snowflake_lookback_window_seconds",
+		"snowflake_up",
+		"snowflake_scrape_duration_seconds",
+		"snowflake_scrape_query_duration_seconds",
+		"snowflake_last_scrape_success_timestamp_seconds",
+		"snowflake_permission_error",
+		"snowflake_db_open_connections",
+		"snowflake_db_in_use_connections",
+		"snowflake_scrape_errors_total",
+		"snowflake_reconnects_total",
+	}
+	assert.Equal(t, len(expected), len(descriptions))
+	for i, name := range expected {
+		assert.Contains(t, descriptions[i], `"`+name+`"`)
+	}
+}
+
+// groupFixtures holds a result row for every metric group, from which the
+// group emits each of its metrics.
+var groupFixtures = map[string]struct {
+	columns []string
+	row     []driver.Value
+}{
+	"warehouse_credits":         {[]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}, []driver.Value{"COMPUTE_WH", 10.5, 10, 0.5, "X-Small"}},
+	"storage_bytes":             {[]string{"database_name", "storage_bytes"}, []driver.Value{"PROD_DB", 1024}},
+	"query_count":               {[]string{"warehouse_name", "query_type", "query_count"}, []driver.Value{"COMPUTE_WH", "SELECT", 120}},
+	"concurrent_queries":        {[]string{"warehouse_name", "concurrent_queries"}, []driver.Value{"COMPUTE_WH", 3}},
+	"failed_logins":             {[]string{"user_name", "error_message", "failed_logins"}, []driver.Value{"ANALYST", "INCORRECT_USERNAME_PASSWORD", 2}},
+	"logins":                    {[]string{"user_name", "client_type", "reported_client_type", "logins"}, []driver.Value{"ANALYST", "SNOWFLAKE_UI", "SNOWFLAKE_UI", 5}},
+	"execution_time":            {[]string{"warehouse_name", "query_count", "total_execution_ms", "p50_execution_ms", "p95_execution_ms"}, []driver.Value{"COMPUTE_WH", 10, 5000, 200, 900}},
+	"bytes_scanned":             {[]string{"warehouse_name", "query_type", "bytes_scanned"}, []driver.Value{"COMPUTE_WH", "SELECT", 4096}},
+	"data_transfer":             {[]string{"source_cloud", "target_cloud", "source_region", "target_region", "transfer_type", "bytes_transferred"}, []driver.Value{"AWS", "AWS", "us-east-1", "eu-west-1", "REPLICATION", 1024}},
+	"automatic_clustering":      {[]string{"table_name", "database_name", "total_credits"}, []driver.Value{"ORDERS", "PROD_DB", 1.5}},
+	"materialized_view_credits": {[]string{"table_name", "schema_name", "database_name", "total_credits"}, []driver.Value{"ORDERS_MV", "PUBLIC", "PROD_DB", 0.5}},
+	"pipe_usage":                {[]string{"pipe_name", "total_credits", "bytes_inserted"}, []driver.Value{"EVENTS_PIPE", 0.25, 2048}},
+	"task_history":              {[]string{"name", "database_name", "schema_name", "state", "task_runs"}, []driver.Value{"NIGHTLY", "PROD_DB", "PUBLIC", "FAILED", 1}},
+	"replication_usage":         {[]string{"database_name", "total_credits", "bytes_transferred"}, []driver.Value{"PROD_DB", 2, 4096}},
+	"serverless_task_credits":   {[]string{"task_name", "database_name", "schema_name", "total_credits"}, []driver.Value{"NIGHTLY", "PROD_DB", "PUBLIC", 0.75}},
+	"queued_time":               {[]string{"warehouse_name", "queued_provisioning_ms", "queued_overload_ms"}, []driver.Value{"COMPUTE_WH", 1000, 2000}},
+	"warehouse_state":           {[]string{"name", "state", "size", "auto_suspend", "auto_resume"}, []driver.Value{"COMPUTE_WH", "STARTED", "X-Small", "600", "true"}},
+	"user_credits":              {[]string{"user_name", "estimated_credits"}, []driver.Value{"ANALYST", 1.25}},
+	"storage_breakdown":         {[]string{"database_name", "active_bytes", "time_travel_bytes", "failsafe_bytes"}, []driver.Value{"PROD_DB", 1024, 512, 256}},
+	"stage_storage":             {[]string{"stage_bytes"}, []driver.Value{8192}},
+	"bytes_spilled":             {[]string{"warehouse_name", "bytes_spilled_local", "bytes_spilled_remote"}, []driver.Value{"COMPUTE_WH", 1024, 512}},
+	"snowflake_version":         {[]string{"CURRENT_VERSION()"}, []driver.Value{"8.40.1"}},
+	"table_storage":             {[]string{"table_catalog", "table_schema", "table_name", "active_bytes"}, []driver.Value{"PROD_DB", "PUBLIC", "ORDERS", 1024}},
+	"failed_queries":            {[]string{"warehouse_name", "error_code", "failed_queries"}, []driver.Value{"COMPUTE_WH", "000604", 7}},
+	"warehouse_load":            {[]string{"warehouse_name", "avg_running", "avg_queued"}, []driver.Value{"COMPUTE_WH", 1.5, 0.5}},
+	"active_users":              {[]string{"active_users"}, []driver.Value{4}},
+	"queries_by_status":         {[]string{"warehouse_name", "execution_status", "query_count"}, []driver.Value{"COMPUTE_WH", "SUCCESS", 100}},
+	"logins_by_client_ip":       {[]string{"user_name", "client_ip", "logins"}, []driver.Value{"ANALYST", "203.0.113.7", 3}},
+	"remaining_balance":         {[]string{"currency", "capacity_balance"}, []driver.Value{"USD", 12500}},
+	"daily_credits":             {[]string{"service_type", "credits_used"}, []driver.Value{"WAREHOUSE_METERING", 12.5}},
+	"clustering_depth":          {[]string{"clustering_depth"}, []driver.Value{3.5}},
+	"query_count_by_database":   {[]string{"database_name", "query_count"}, []driver.Value{"PROD_DB", 42}},
+	"compilation_time":          {[]string{"warehouse_name", "avg_compilation_ms"}, []driver.Value{"COMPUTE_WH", 250}},
+}
+
+func TestSnowflakeMetricsCollector_GroupsDescribedAndCollected(t *testing.T) {
+	for _, group := range newSnowflakeMetricsCollector(nil).metricGroups() {
+		t.Run(group.name, func(t *testing.T) {
+			fixture, ok := groupFixtures[group.name]
+			if !assert.True(t, ok, "no fixture for metric group %s", group.name) {
+				return
+			}
+
+			// Every group runs a single query, whatever its SQL
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(
+				func(string, string) error { return nil })))
+			assert.NoError(t, err)
+			defer db.Close()
+			mock.ExpectQuery("").WillReturnRows(sqlmock.NewRows(fixture.columns).AddRow(fixture.row...))
+
+			collector := newSnowflakeMetricsCollector(db)
+			collector.enabledGroups = onlyGroup(group.name)
+			collector.clusteringTables = []string{"PROD_DB.PUBLIC.ORDERS"}
+
+			descCh := make(chan *prometheus.Desc, 100)
+			collector.Describe(descCh)
+			close(descCh)
+			described := map[string]bool{}
+			for desc := range descCh {
+				described[desc.String()] = true
+			}
+
+			metrics, err := collector.scrape(context.Background())
+			assert.NoError(t, err)
+			collected := map[string]bool{}
+			for _, metric := range metrics {
+				collected[metric.Desc().String()] = true
+			}
+
+			for _, desc := range group.descs {
+				assert.True(t, described[desc.String()], "%s is not described", desc)
+				assert.True(t, collected[desc.String()], "%s is not collected", desc)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSnowflakeMetricsCollector_QueryCount(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Prepare mock rows for query count
+	queryCountRows := sqlmock.NewRows([]string{"warehouse_name", "query_type", "query_count"}).
+		AddRow("COMPUTE_WH", "SELECT", 120).
+		AddRow("COMPUTE_WH", "INSERT", 15).
+		AddRow("REPORTING_WH", "SELECT", 42)
+	mock.ExpectQuery("SELECT warehouse_name, query_type, COUNT\\(\\*\\) as query_count").
+		WillReturnRows(queryCountRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("query_count")
+
+	// Validate query count metrics
+	expectedQueryCount := `
+		# HELP snowflake_query_count Number of queries executed
+		# TYPE snowflake_query_count gauge
+		snowflake_query_count{query_type="INSERT",warehouse_name="COMPUTE_WH"} 15
+		snowflake_query_count{query_type="SELECT",warehouse_name="COMPUTE_WH"} 120
+		snowflake_query_count{query_type="SELECT",warehouse_name="REPORTING_WH"} 42
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expectedQueryCount),
+		"snowflake_query_count")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueryCountByUser(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, query_type, user_name, COUNT\\(\\*\\) as query_count .* GROUP BY warehouse_name, query_type, user_name").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_type", "user_name", "query_count"}).
+			AddRow("COMPUTE_WH", "SELECT", "ETL", 100).
+			AddRow("COMPUTE_WH", "SELECT", "ANALYST", 20).
+			AddRow("COMPUTE_WH", "INSERT", nil, 5))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("query_count")
+	collector.queryCountByUser = true
+
+	expected := `
+		# HELP snowflake_query_count Number of queries executed
+		# TYPE snowflake_query_count gauge
+		snowflake_query_count{query_type="SELECT",user_name="ANALYST",warehouse_name="COMPUTE_WH"} 20
+		snowflake_query_count{query_type="SELECT",user_name="ETL",warehouse_name="COMPUTE_WH"} 100
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_query_count")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// The descriptor follows the label set
+	ch := make(chan *prometheus.Desc, 100)
+	collector.Describe(ch)
+	close(ch)
+	for desc := range ch {
+		if strings.Contains(desc.String(), `"snowflake_query_count"`) {
+			assert.Contains(t, desc.String(), "user_name")
+		}
+	}
+}
+
+func TestSnowflakeMetricsCollector_ConcurrentQueries(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Groups run concurrently, so queries may arrive in any order
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}))
+	mock.ExpectQuery("SELECT warehouse_name, query_type, COUNT\\(\\*\\) as query_count").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_type", "query_count"}))
+
+	// Prepare mock rows for concurrent queries
+	concurrentRows := sqlmock.NewRows([]string{"warehouse_name", "concurrent_queries"}).
+		AddRow("COMPUTE_WH", 4).
+		AddRow("REPORTING_WH", 1)
+	mock.ExpectQuery("SELECT warehouse_name, COUNT\\(\\*\\) as concurrent_queries .* WHERE execution_status = 'RUNNING'\\s+AND start_time > dateadd\\(day, -7, current_timestamp\\(\\)\\)").
+		WillReturnRows(concurrentRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+
+	// Validate concurrent query metrics
+	expectedConcurrentQueries := `
+		# HELP snowflake_concurrent_queries Number of concurrent queries
+		# TYPE snowflake_concurrent_queries gauge
+		snowflake_concurrent_queries{warehouse_name="COMPUTE_WH"} 4
+		snowflake_concurrent_queries{warehouse_name="REPORTING_WH"} 1
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expectedConcurrentQueries),
+		"snowflake_concurrent_queries")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestGoVet runs go vet over the package so that compile errors and common
+// mistakes in main.go fail the test suite.
+func TestGoVet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go vet in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found in PATH")
+	}
+
+	out, err := exec.Command(goBin, "vet", ".").CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
+
+func TestSnowflakeMetricsCollector_WarehouseCreditsMetricName(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 1.0, nil, nil, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+
+	// The field rename must not change the exported metric name
+	assert.Contains(t, collector.warehouseCredits.String(), `"snowflake_warehouse_credits_used"`)
+
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+}
+
+func TestLookbackStart(t *testing.T) {
+	tests := []struct {
+		lookback time.Duration
+		expected string
+	}{
+		{24 * time.Hour, "dateadd(day, -1, current_timestamp())"},
+		{7 * 24 * time.Hour, "dateadd(day, -7, current_timestamp())"},
+		{6 * time.Hour, "dateadd(hour, -6, current_timestamp())"},
+		{36 * time.Hour, "dateadd(hour, -36, current_timestamp())"},
+		{90 * time.Minute, "dateadd(minute, -90, current_timestamp())"},
+		{45 * time.Second, "dateadd(second, -45, current_timestamp())"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, lookbackStart(tt.lookback), tt.lookback.String())
+	}
+}
+
+func TestSnowflakeMetricsCollector_Lookback(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The configured window is used in the history queries
+	mock.ExpectQuery("WHERE start_time > dateadd\\(hour, -6, current_timestamp\\(\\)\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.lookback = 6 * time.Hour
+
+	expected := `
+		# HELP snowflake_lookback_window_seconds Lookback window used by the history queries
+		# TYPE snowflake_lookback_window_seconds gauge
+		snowflake_lookback_window_seconds 21600
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_lookback_window_seconds")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectSuccessfulScrape registers sqlmock expectations for one scrape in
+// which every query succeeds, returning a single warehouse credits row.
+func expectSuccessfulScrape(mock sqlmock.Sqlmock) {
+	// Groups run concurrently, so queries may arrive in any order
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}))
+	mock.ExpectQuery("SELECT warehouse_name, query_type, COUNT\\(\\*\\) as query_count").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_type", "query_count"}))
+	mock.ExpectQuery("SELECT warehouse_name, COUNT\\(\\*\\) as concurrent_queries").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "concurrent_queries"}))
+	mock.ExpectQuery("SELECT user_name, error_message, COUNT\\(\\*\\) as failed_logins").
+		WillReturnRows(sqlmock.NewRows([]string{"user_name", "error_message", "failed_logins"}))
+	mock.ExpectQuery("SELECT user_name, .* COUNT\\(\\*\\) as logins").
+		WillReturnRows(sqlmock.NewRows([]string{"user_name", "client_type", "reported_client_type", "logins"}))
+	mock.ExpectQuery("SELECT warehouse_name, COUNT\\(\\*\\) as query_count, SUM\\(execution_time\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_count", "total_execution_ms", "p50_execution_ms", "p95_execution_ms"}))
+	mock.ExpectQuery("SELECT warehouse_name, query_type, SUM\\(bytes_scanned\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_type", "bytes_scanned"}))
+	mock.ExpectQuery("SELECT source_cloud, target_cloud, source_region, target_region, transfer_type").
+		WillReturnRows(sqlmock.NewRows([]string{"source_cloud", "target_cloud", "source_region", "target_region", "transfer_type", "bytes_transferred"}))
+	mock.ExpectQuery("SELECT table_name, database_name, SUM\\(credits_used\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "database_name", "total_credits"}))
+	mock.ExpectQuery("SELECT table_name, schema_name, database_name, SUM\\(credits_used\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "schema_name", "database_name", "total_credits"}))
+	mock.ExpectQuery("SELECT pipe_name, SUM\\(credits_used\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"pipe_name", "total_credits", "bytes_inserted"}))
+	mock.ExpectQuery("SELECT name, database_name, schema_name, state, COUNT\\(\\*\\) as task_runs").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "database_name", "schema_name", "state", "task_runs"}))
+	mock.ExpectQuery("SELECT database_name, SUM\\(credits_used\\) as total_credits, SUM\\(bytes_transferred\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "total_credits", "bytes_transferred"}))
+	mock.ExpectQuery("SELECT task_name, database_name, schema_name, SUM\\(credits_used\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"task_name", "database_name", "schema_name", "total_credits"}))
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(queued_provisioning_time\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "queued_provisioning_ms", "queued_overload_ms"}))
+	mock.ExpectQuery("SHOW WAREHOUSES").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "state", "size"}))
+	mock.ExpectQuery("SELECT u.user_name, SUM\\(m.credits_used_compute").
+		WillReturnRows(sqlmock.NewRows([]string{"user_name", "estimated_credits"}))
+	mock.ExpectQuery("SELECT table_catalog as database_name, SUM\\(active_bytes\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "active_bytes", "time_travel_bytes", "failsafe_bytes"}))
+	mock.ExpectQuery("SELECT SUM\\(average_stage_bytes\\) as stage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"stage_bytes"}))
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(bytes_spilled_to_local_storage\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "bytes_spilled_local", "bytes_spilled_remote"}))
+	mock.ExpectQuery("SELECT CURRENT_VERSION\\(\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"CURRENT_VERSION()"}).AddRow("8.40.1"))
+	mock.ExpectQuery("SELECT warehouse_name, error_code, COUNT\\(\\*\\) as failed_queries").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "error_code", "failed_queries"}))
+	mock.ExpectQuery("SELECT warehouse_name, AVG\\(avg_running\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "avg_running", "avg_queued"}))
+	mock.ExpectQuery("SELECT COUNT\\(DISTINCT user_name\\) as active_users").
+		WillReturnRows(sqlmock.NewRows([]string{"active_users"}))
+	mock.ExpectQuery("SELECT warehouse_name, execution_status, COUNT\\(\\*\\) as query_count").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "execution_status", "query_count"}))
+	mock.ExpectQuery("SELECT service_type, SUM\\(credits_used\\) as credits_used").
+		WillReturnRows(sqlmock.NewRows([]string{"service_type", "credits_used"}))
+	mock.ExpectQuery("SELECT database_name, COUNT\\(\\*\\) as query_count").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "query_count"}))
+	mock.ExpectQuery("SELECT warehouse_name, AVG\\(compilation_time\\) as avg_compilation_ms").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "avg_compilation_ms"}))
+}
+
+// onlyGroup returns an enabledGroups map that disables every metric group
+// except the named one.
+func onlyGroup(name string) map[string]bool {
+	enabled := map[string]bool{}
+	for _, group := range metricGroupNames() {
+		enabled[group] = group == name
+	}
+	return enabled
+}
+
+func TestSnowflakeMetricsCollector_Cache(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Only one scrape's worth of queries is expected
+	expectSuccessfulScrape(mock)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := newSnowflakeMetricsCollector(db)
+	collector.cacheTTL = 5 * time.Minute
+	collector.now = func() time.Time { return now }
+
+	// Repeated scrapes within the TTL are served from the cache
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 1, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+		now = now.Add(time.Minute)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Once the TTL expires Snowflake is queried again
+	expectSuccessfulScrape(mock)
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_CacheSkipsFailedScrape(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The first scrape fails, the second succeeds
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("database connection error"))
+	expectSuccessfulScrape(mock)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.cacheTTL = time.Hour
+
+	assert.Equal(t, 0, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_GroupCacheTTLs(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	expectGroups := func(storage bool) {
+		mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+			WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+				AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+		if storage {
+			mock.ExpectQuery("SELECT database_name, storage_bytes").
+				WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}).
+					AddRow("PROD_DB", 1024000))
+		}
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = map[string]bool{}
+	for _, name := range metricGroupNames() {
+		collector.enabledGroups[name] = name == "warehouse_credits" || name == "storage_bytes"
+	}
+	collector.cacheTTL = 5 * time.Minute
+	collector.groupTTLs = map[string]time.Duration{"storage_bytes": time.Hour}
+	collector.now = func() time.Time { return now }
+
+	expectGroups(true)
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "snowflake_storage_bytes"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Past the default TTL only warehouse_credits is queried again, while
+	// storage_bytes is still served from its own cache
+	for i := 0; i < 3; i++ {
+		now = now.Add(10 * time.Minute)
+		expectGroups(false)
+		assert.Equal(t, 2, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used", "snowflake_storage_bytes"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	// Once its own TTL expires storage_bytes is refreshed too
+	now = now.Add(30 * time.Minute)
+	expectGroups(true)
+	assert.Equal(t, 2, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used", "snowflake_storage_bytes"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueryTimeout(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+	// The storage query is slower than the query timeout
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}).
+			AddRow("PROD_DB", 1024000))
+
+	// The other groups are not held up by the timeout
+	mock.ExpectQuery("SELECT warehouse_name, query_type, COUNT\\(\\*\\) as query_count").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_type", "query_count"}).
+			AddRow("COMPUTE_WH", "SELECT", 120))
+	mock.ExpectQuery("SELECT warehouse_name, COUNT\\(\\*\\) as concurrent_queries").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "concurrent_queries"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.queryTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	metrics, err := collector.scrape(context.Background())
+	elapsed := time.Since(start)
+
+	assert.Error(t, err)
+	assert.Less(t, elapsed, time.Second)
+
+	var names []string
+	for _, metric := range metrics {
+		names = append(names, metric.Desc().String())
+	}
+	joined := strings.Join(names, "\n")
+	assert.Contains(t, joined, `"snowflake_warehouse_credits_used"`)
+	assert.Contains(t, joined, `"snowflake_query_count"`)
+	assert.NotContains(t, joined, `"snowflake_storage_bytes"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ScrapeTimeout(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+	// Within its query timeout, but beyond the scrape deadline
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillDelayFor(5 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}).
+			AddRow("PROD_DB", 1024000))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.enabledGroups["storage_bytes"] = true
+	collector.queryTimeout = 10 * time.Second
+	collector.scrapeTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	expected := `
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 0
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 10.5
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_storage_bytes", "snowflake_up")
+	assert.NoError(t, err)
+
+	// The slow query was cancelled at the deadline
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("storage_bytes")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_Up(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	expectSuccessfulScrape(mock)
+
+	collector := newSnowflakeMetricsCollector(db)
+
+	expected := `
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 1
+	`
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected), "snowflake_up")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_UpOnQueryError(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("database connection error"))
+
+	collector := newSnowflakeMetricsCollector(db)
+
+	expected := `
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 0
+	`
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected), "snowflake_up")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ScrapeDuration(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	expectSuccessfulScrape(mock)
+
+	collector := newSnowflakeMetricsCollector(db)
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(collector))
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+
+	durations := map[string]int{}
+	for _, family := range families {
+		durations[family.GetName()] = len(family.GetMetric())
+	}
+	assert.Equal(t, 1, durations["snowflake_scrape_duration_seconds"])
+	// One series per enabled query
+	assert.Equal(t, len(collector.enabledMetricGroups()), durations["snowflake_scrape_query_duration_seconds"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ScrapeErrors(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	collector := newSnowflakeMetricsCollector(db)
+	collector.cacheTTL = 0
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	// A failing query increments its counter by one
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("database connection error"))
+	assert.Equal(t, 0, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_credits")))
+
+	// Errors accumulate across scrapes, including row scan failures
+	collector.enabledGroups["storage_bytes"] = true
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", "not a number", nil, nil, nil))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnError(fmt.Errorf("database connection error"))
+	assert.Equal(t, 0, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_credits")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("storage_bytes")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("query_count")))
+	assert.Equal(t, len(metricGroupNames()), testutil.CollectAndCount(collector.scrapeErrors))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_DisabledGroup(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Queries of disabled groups are never issued
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	_, err = collector.scrape(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRun_ShutsDownOnCancel(t *testing.T) {
+	cfg := Config{
+		Connection: connectionConfig{
+			Account:  "myaccount",
+			User:     "exporter",
+			Password: "secret",
+		},
+		Pool: poolConfig{
+			MaxOpenConns:    defaultMaxOpenConns,
+			MaxIdleConns:    defaultMaxIdleConns,
+			ConnMaxLifetime: defaultConnMaxLifetime,
+		},
+		ListenPort:   "0",
+		MetricsPath:  defaultMetricsPath,
+		CacheTTL:     defaultCacheTTL,
+		QueryTimeout: defaultQueryTimeout,
+		lookback:     defaultLookback,
+
+		MaxConcurrentQueries: defaultMaxConcurrentQueries,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, cfg, nil)
+	}()
+
+	// Give the server a moment to start, then ask it to stop
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after the context was cancelled")
+	}
+}
+
+func TestRun_ListenErrorWithRefresher(t *testing.T) {
+	// Occupy the port so run fails after starting the refresher
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, err)
+
+	// With every group disabled the refreshes issue no queries
+	groups := map[string]bool{}
+	for _, name := range metricGroupNames() {
+		groups[name] = false
+	}
+	cfg := Config{
+		Connection: connectionConfig{
+			Account:  "myaccount",
+			User:     "exporter",
+			Password: "secret",
+		},
+		Pool: poolConfig{
+			MaxOpenConns:    defaultMaxOpenConns,
+			MaxIdleConns:    defaultMaxIdleConns,
+			ConnMaxLifetime: defaultConnMaxLifetime,
+		},
+		ListenAddress:   "127.0.0.1:" + port,
+		ListenPort:      port,
+		MetricsPath:     defaultMetricsPath,
+		CacheTTL:        defaultCacheTTL,
+		QueryTimeout:    defaultQueryTimeout,
+		RefreshInterval: time.Hour,
+		MetricGroups:    groups,
+		lookback:        defaultLookback,
+
+		MaxConcurrentQueries: defaultMaxConcurrentQueries,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(context.Background(), cfg, nil)
+	}()
+
+	select {
+	case err := <-done:
+		assert.ErrorContains(t, err, "failed to listen")
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after failing to listen")
+	}
+}
+
+func TestSnowflakeMetricsCollector_FailedLogins(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Prepare mock rows for failed logins
+	failedLoginRows := sqlmock.NewRows([]string{"user_name", "error_message", "failed_logins"}).
+		AddRow("ALICE", "INCORRECT_USERNAME_PASSWORD", 3).
+		AddRow("ALICE", "USER_LOCKED_TEMPORARILY", 1).
+		AddRow("BOB", "INCORRECT_USERNAME_PASSWORD", 7)
+	mock.ExpectQuery("SELECT user_name, error_message, COUNT\\(\\*\\) as failed_logins .* WHERE is_success = 'NO'").
+		WillReturnRows(failedLoginRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("failed_logins")
+
+	expected := `
+		# HELP snowflake_failed_logins_total Number of failed logins over the lookback window
+		# TYPE snowflake_failed_logins_total gauge
+		snowflake_failed_logins_total{error_message="INCORRECT_USERNAME_PASSWORD",user_name="ALICE"} 3
+		snowflake_failed_logins_total{error_message="USER_LOCKED_TEMPORARILY",user_name="ALICE"} 1
+		snowflake_failed_logins_total{error_message="INCORRECT_USERNAME_PASSWORD",user_name="BOB"} 7
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_failed_logins_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_FailedLoginsScanError(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A bad row is skipped while the others are still emitted
+	failedLoginRows := sqlmock.NewRows([]string{"user_name", "error_message", "failed_logins"}).
+		AddRow("ALICE", "INCORRECT_USERNAME_PASSWORD", "not a number").
+		AddRow("BOB", "INCORRECT_USERNAME_PASSWORD", 7)
+	mock.ExpectQuery("SELECT user_name, error_message, COUNT\\(\\*\\) as failed_logins").
+		WillReturnRows(failedLoginRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("failed_logins")
+
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "snowflake_failed_logins_total"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("failed_logins")))
+}
+
+func TestSnowflakeMetricsCollector_Logins(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Prepare mock rows for successful logins with several client types
+	loginRows := sqlmock.NewRows([]string{"user_name", "client_type", "reported_client_type", "logins"}).
+		AddRow("ALICE", "JDBC", "JDBC_DRIVER", 12).
+		AddRow("ALICE", "SNOWFLAKE", "SNOWFLAKE_UI", 2).
+		AddRow("EXPORTER", "GO", "GO_DRIVER", 96).
+		AddRow("BOB", "ODBC", "ODBC_DRIVER", 5)
+	mock.ExpectQuery("SELECT user_name, .* COUNT\\(\\*\\) as logins .* WHERE is_success = 'YES'").
+		WillReturnRows(loginRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("logins")
+
+	expected := `
+		# HELP snowflake_logins_total Number of successful logins over the lookback window
+		# TYPE snowflake_logins_total gauge
+		snowflake_logins_total{client_type="JDBC",reported_client_type="JDBC_DRIVER",user_name="ALICE"} 12
+		snowflake_logins_total{client_type="SNOWFLAKE",reported_client_type="SNOWFLAKE_UI",user_name="ALICE"} 2
+		snowflake_logins_total{client_type="ODBC",reported_client_type="ODBC_DRIVER",user_name="BOB"} 5
+		snowflake_logins_total{client_type="GO",reported_client_type="GO_DRIVER",user_name="EXPORTER"} 96
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_logins_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ExecutionTime(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Execution times are reported in milliseconds
+	executionTimeRows := sqlmock.NewRows([]string{"warehouse_name", "query_count", "total_execution_ms", "p50_execution_ms", "p95_execution_ms"}).
+		AddRow("COMPUTE_WH", 120, 360000, 1500, 12000).
+		AddRow("REPORTING_WH", 10, 2500, 200, 750)
+	mock.ExpectQuery("SELECT warehouse_name, COUNT\\(\\*\\) as query_count, SUM\\(execution_time\\) .* APPROX_PERCENTILE\\(execution_time, 0.95\\)").
+		WillReturnRows(executionTimeRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("execution_time")
+
+	expected := `
+		# HELP snowflake_query_execution_time_seconds Query execution time over the lookback window
+		# TYPE snowflake_query_execution_time_seconds summary
+		snowflake_query_execution_time_seconds{warehouse_name="COMPUTE_WH",quantile="0.5"} 1.5
+		snowflake_query_execution_time_seconds{warehouse_name="COMPUTE_WH",quantile="0.95"} 12
+		snowflake_query_execution_time_seconds_sum{warehouse_name="COMPUTE_WH"} 360
+		snowflake_query_execution_time_seconds_count{warehouse_name="COMPUTE_WH"} 120
+		snowflake_query_execution_time_seconds{warehouse_name="REPORTING_WH",quantile="0.5"} 0.2
+		snowflake_query_execution_time_seconds{warehouse_name="REPORTING_WH",quantile="0.95"} 0.75
+		snowflake_query_execution_time_seconds_sum{warehouse_name="REPORTING_WH"} 2.5
+		snowflake_query_execution_time_seconds_count{warehouse_name="REPORTING_WH"} 10
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_query_execution_time_seconds")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_BytesScanned(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A NULL sum is reported as zero
+	bytesScannedRows := sqlmock.NewRows([]string{"warehouse_name", "query_type", "bytes_scanned"}).
+		AddRow("COMPUTE_WH", "SELECT", 5000000000).
+		AddRow("COMPUTE_WH", "SHOW", nil).
+		AddRow("REPORTING_WH", "SELECT", 120000)
+	mock.ExpectQuery("SELECT warehouse_name, query_type, SUM\\(bytes_scanned\\)").
+		WillReturnRows(bytesScannedRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("bytes_scanned")
+
+	expected := `
+		# HELP snowflake_bytes_scanned_total Bytes scanned by queries over the lookback window
+		# TYPE snowflake_bytes_scanned_total gauge
+		snowflake_bytes_scanned_total{query_type="SELECT",warehouse_name="COMPUTE_WH"} 5e+09
+		snowflake_bytes_scanned_total{query_type="SHOW",warehouse_name="COMPUTE_WH"} 0
+		snowflake_bytes_scanned_total{query_type="SELECT",warehouse_name="REPORTING_WH"} 120000
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_bytes_scanned_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("bytes_scanned")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_NullValues(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+
+	// NULL values are zero-filled, rows with a NULL name are skipped
+	warehouseCreditRows := sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+		AddRow("COMPUTE_WH", 10.5, nil, nil, nil).
+		AddRow("IDLE_WH", nil, nil, nil, nil).
+		AddRow(nil, 3.0, nil, nil, nil)
+	storageRows := sqlmock.NewRows([]string{"database_name", "storage_bytes"}).
+		AddRow("PROD_DB", 1024000).
+		AddRow("EMPTY_DB", nil).
+		AddRow(nil, nil)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(warehouseCreditRows)
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnRows(storageRows)
+
+	// An idle warehouse has no percentiles
+	executionTimeRows := sqlmock.NewRows([]string{"warehouse_name", "query_count", "total_execution_ms", "p50_execution_ms", "p95_execution_ms"}).
+		AddRow("COMPUTE_WH", 120, 360000, 1500, 12000).
+		AddRow("IDLE_WH", 0, nil, nil, nil).
+		AddRow(nil, 5, 1000, 200, 400)
+	bytesScannedRows := sqlmock.NewRows([]string{"warehouse_name", "query_type", "bytes_scanned"}).
+		AddRow("COMPUTE_WH", "SELECT", 5000).
+		AddRow("COMPUTE_WH", nil, 100).
+		AddRow(nil, "SELECT", 200)
+	mock.ExpectQuery("SELECT warehouse_name, COUNT\\(\\*\\) as query_count, SUM\\(execution_time\\)").
+		WillReturnRows(executionTimeRows)
+	mock.ExpectQuery("SELECT warehouse_name, query_type, SUM\\(bytes_scanned\\)").
+		WillReturnRows(bytesScannedRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = map[string]bool{}
+	for _, name := range metricGroupNames() {
+		switch name {
+		case "warehouse_credits", "storage_bytes", "execution_time", "bytes_scanned":
+			collector.enabledGroups[name] = true
+		default:
+			collector.enabledGroups[name] = false
+		}
+	}
+
+	expected := `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 10.5
+		snowflake_warehouse_credits_used{size="",warehouse_name="IDLE_WH"} 0
+		# HELP snowflake_storage_bytes Total storage used in bytes
+		# TYPE snowflake_storage_bytes gauge
+		snowflake_storage_bytes{database_name="PROD_DB"} 1024000
+		snowflake_storage_bytes{database_name="EMPTY_DB"} 0
+		# HELP snowflake_query_execution_time_seconds Query execution time over the lookback window
+		# TYPE snowflake_query_execution_time_seconds summary
+		snowflake_query_execution_time_seconds{warehouse_name="COMPUTE_WH",quantile="0.5"} 1.5
+		snowflake_query_execution_time_seconds{warehouse_name="COMPUTE_WH",quantile="0.95"} 12
+		snowflake_query_execution_time_seconds_sum{warehouse_name="COMPUTE_WH"} 360
+		snowflake_query_execution_time_seconds_count{warehouse_name="COMPUTE_WH"} 120
+		snowflake_query_execution_time_seconds{warehouse_name="IDLE_WH",quantile="0.5"} 0
+		snowflake_query_execution_time_seconds{warehouse_name="IDLE_WH",quantile="0.95"} 0
+		snowflake_query_execution_time_seconds_sum{warehouse_name="IDLE_WH"} 0
+		snowflake_query_execution_time_seconds_count{warehouse_name="IDLE_WH"} 0
+		# HELP snowflake_bytes_scanned_total Bytes scanned by queries over the lookback window
+		# TYPE snowflake_bytes_scanned_total gauge
+		snowflake_bytes_scanned_total{query_type="SELECT",warehouse_name="COMPUTE_WH"} 5000
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_storage_bytes",
+		"snowflake_query_execution_time_seconds", "snowflake_bytes_scanned_total")
+	assert.NoError(t, err)
+
+	// NULLs are not scan errors
+	for _, group := range []string{"warehouse_credits", "storage_bytes", "execution_time", "bytes_scanned"} {
+		assert.Equal(t, 0.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues(group)), group)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_PartialFailure(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The credits query fails but the storage query still runs
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("database connection error"))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}).
+			AddRow("PROD_DB", 1024000))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.enabledGroups["storage_bytes"] = true
+
+	expected := `
+		# HELP snowflake_storage_bytes Total storage used in bytes
+		# TYPE snowflake_storage_bytes gauge
+		snowflake_storage_bytes{database_name="PROD_DB"} 1.024e+06
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 0
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_storage_bytes", "snowflake_up")
+	assert.NoError(t, err)
+
+	// Only the failing group is counted as an error
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_credits")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("storage_bytes")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueryErrorIsLogged(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("database connection error"))
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "debug", "json")
+	assert.NoError(t, err)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.logger = logger
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	_, err = collector.scrape(context.Background())
+	assert.Error(t, err)
+
+	var errorRecords []map[string]interface{}
+	for _, record := range logRecords(t, buf.String()) {
+		if record["level"] == "ERROR" {
+			errorRecords = append(errorRecords, record)
+		}
+	}
+	assert.Len(t, errorRecords, 1)
+	assert.Equal(t, "Error fetching metrics", errorRecords[0]["msg"])
+	assert.Equal(t, "warehouse_credits", errorRecords[0]["query"])
+	assert.Equal(t, "database connection error", errorRecords[0]["err"])
+}
+
+func TestSnowflakeMetricsCollector_QueryAttributionLogging(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil).
+			AddRow("REPORTING_WH", 2, nil, nil, nil))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnError(fmt.Errorf("database connection error"))
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "debug", "json")
+	assert.NoError(t, err)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.logger = logger
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.enabledGroups["storage_bytes"] = true
+
+	_, err = collector.scrape(context.Background())
+	assert.Error(t, err)
+
+	ran := map[string]map[string]interface{}{}
+	for _, record := range logRecords(t, buf.String()) {
+		if record["msg"] == "Ran query" {
+			assert.Equal(t, "DEBUG", record["level"])
+			assert.NotContains(t, ran, record["query"])
+			ran[record["query"].(string)] = record
+		}
+	}
+	assert.Len(t, ran, 2)
+	if assert.Contains(t, ran, "warehouse_credits") {
+		assert.Equal(t, 6.0, ran["warehouse_credits"]["samples"])
+		assert.Equal(t, false, ran["warehouse_credits"]["failed"])
+		assert.Contains(t, ran["warehouse_credits"], "duration")
+	}
+	if assert.Contains(t, ran, "storage_bytes") {
+		assert.Equal(t, 0.0, ran["storage_bytes"]["samples"])
+		assert.Equal(t, true, ran["storage_bytes"]["failed"])
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueryAttributionNotLoggedAtInfo(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "info", "json")
+	assert.NoError(t, err)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.logger = logger
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	_, err = collector.scrape(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, logRecords(t, buf.String()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_WarehouseFilter(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+
+	// Both per-warehouse queries bind the allowlist instead of inlining it
+	mock.ExpectQuery("FROM snowflake.account_usage.warehouse_metering_history\\s+LEFT JOIN .*\\) latest_size USING \\(warehouse_name\\)\\s+WHERE start_time > .* AND warehouse_name IN \\(\\?, \\?\\)").
+		WithArgs("COMPUTE_WH", "REPORTING_WH").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 12.5, nil, nil, nil).
+			AddRow("REPORTING_WH", 3, nil, nil, nil))
+	mock.ExpectQuery("SELECT warehouse_name, query_type, COUNT.*FROM snowflake.account_usage.query_history\\s+WHERE start_time > .* AND warehouse_name IN \\(\\?, \\?\\)").
+		WithArgs("COMPUTE_WH", "REPORTING_WH").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_type", "query_count"}).
+			AddRow("COMPUTE_WH", "SELECT", 120))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.warehouseFilter = []string{"COMPUTE_WH", "REPORTING_WH"}
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.enabledGroups["query_count"] = true
+
+	expected := `
+		# HELP snowflake_query_count Number of queries executed
+		# TYPE snowflake_query_count gauge
+		snowflake_query_count{query_type="SELECT",warehouse_name="COMPUTE_WH"} 120
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 12.5
+		snowflake_warehouse_credits_used{size="",warehouse_name="REPORTING_WH"} 3
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_query_count")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_DisabledGroupNotDescribed(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	expectSuccessfulScrape(mock)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = map[string]bool{"storage_bytes": false, "pipe_usage": false}
+
+	// The disabled groups are neither described nor collected
+	disabled := []string{"snowflake_storage_bytes", "snowflake_pipe_credits_used", "snowflake_pipe_bytes_inserted_total"}
+	ch := make(chan *prometheus.Desc, 100)
+	collector.Describe(ch)
+	close(ch)
+	for desc := range ch {
+		for _, name := range disabled {
+			assert.NotContains(t, desc.String(), `"`+name+`"`)
+		}
+	}
+
+	metrics, err := collector.scrape(context.Background())
+	assert.NoError(t, err)
+	for _, metric := range metrics {
+		for _, name := range disabled {
+			assert.NotContains(t, metric.Desc().String(), `"`+name+`"`)
+		}
+	}
+}
+
+func TestSnowflakeMetricsCollector_QueryOverride(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The custom query runs in place of the built-in one, without the
+	// warehouse filter bound to it
+	mock.ExpectQuery("^SELECT warehouse_name, credits, compute_credits, cloud_services_credits FROM monitoring.public.daily_credits$").
+		WithArgs().
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 42, 40, 2, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.warehouseFilter = []string{"COMPUTE_WH"}
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.queryOverrides = map[string]string{
+		"warehouse_credits": "SELECT warehouse_name, credits, compute_credits, cloud_services_credits FROM monitoring.public.daily_credits",
+	}
+
+	expected := `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 42
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueryOverrideColumnMismatch(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// An override returning the wrong columns emits nothing for the
+	// group and counts the rows it could not read
+	mock.ExpectQuery("SELECT warehouse_name FROM monitoring.public.daily_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name"}).
+			AddRow("COMPUTE_WH"))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.queryOverrides = map[string]string{
+		"warehouse_credits": "SELECT warehouse_name FROM monitoring.public.daily_credits",
+	}
+
+	err = testutil.CollectAndCompare(collector, strings.NewReader(""), "snowflake_warehouse_credits_used")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_credits")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWarehouseFilterClause(t *testing.T) {
+	clause, args := warehouseFilterClause(nil)
+	assert.Equal(t, "", clause)
+	assert.Empty(t, args)
+
+	// Names are bound, never interpolated into the SQL
+	clause, args = warehouseFilterClause([]string{"COMPUTE_WH", "X') OR 1=1 --"})
+	assert.Equal(t, " AND warehouse_name IN (?, ?)", clause)
+	assert.Equal(t, []interface{}{"COMPUTE_WH", "X') OR 1=1 --"}, args)
+}
+
+func TestSnowflakeMetricsCollector_DataTransfer(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// NULL regions are reported as empty labels rather than dropped
+	dataTransferRows := sqlmock.NewRows([]string{"source_cloud", "target_cloud", "source_region", "target_region", "transfer_type", "bytes_transferred"}).
+		AddRow("AWS", "AWS", "us-east-1", "eu-west-1", "REPLICATION", 2048).
+		AddRow("AWS", "AZURE", "us-east-1", "eastus2", "COPY", 1024).
+		AddRow("AWS", "AWS", "us-east-1", nil, "EXTERNAL_FUNCTION", 512)
+	mock.ExpectQuery("SELECT source_cloud, target_cloud, source_region, target_region, transfer_type").
+		WillReturnRows(dataTransferRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("data_transfer")
+
+	expected := `
+		# HELP snowflake_data_transfer_bytes_total Bytes transferred between clouds and regions over the lookback window
+		# TYPE snowflake_data_transfer_bytes_total gauge
+		snowflake_data_transfer_bytes_total{source_cloud="AWS",source_region="us-east-1",target_cloud="AWS",target_region="",transfer_type="EXTERNAL_FUNCTION"} 512
+		snowflake_data_transfer_bytes_total{source_cloud="AWS",source_region="us-east-1",target_cloud="AWS",target_region="eu-west-1",transfer_type="REPLICATION"} 2048
+		snowflake_data_transfer_bytes_total{source_cloud="AWS",source_region="us-east-1",target_cloud="AZURE",target_region="eastus2",transfer_type="COPY"} 1024
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_data_transfer_bytes_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_AutomaticClustering(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Rows with a NULL table name are skipped and NULL credits count as zero
+	clusteringRows := sqlmock.NewRows([]string{"table_name", "database_name", "total_credits"}).
+		AddRow("EVENTS", "ANALYTICS", 4.25).
+		AddRow("ORDERS", "SALES", 1.5).
+		AddRow("CUSTOMERS", "SALES", nil).
+		AddRow(nil, "SALES", 9)
+	mock.ExpectQuery("SELECT table_name, database_name, SUM\\(credits_used\\)").
+		WillReturnRows(clusteringRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("automatic_clustering")
+
+	expected := `
+		# HELP snowflake_automatic_clustering_credits_used Credits used by automatic clustering over the lookback window
+		# TYPE snowflake_automatic_clustering_credits_used gauge
+		snowflake_automatic_clustering_credits_used{database_name="ANALYTICS",table_name="EVENTS"} 4.25
+		snowflake_automatic_clustering_credits_used{database_name="SALES",table_name="CUSTOMERS"} 0
+		snowflake_automatic_clustering_credits_used{database_name="SALES",table_name="ORDERS"} 1.5
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_automatic_clustering_credits_used")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_MaterializedViewCredits(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The view with NULL credits is skipped
+	materializedViewRows := sqlmock.NewRows([]string{"table_name", "schema_name", "database_name", "total_credits"}).
+		AddRow("DAILY_REVENUE_MV", "REPORTING", "SALES", 0.75).
+		AddRow("SESSIONS_MV", "PUBLIC", "ANALYTICS", 2).
+		AddRow("STALE_MV", "PUBLIC", "ANALYTICS", nil)
+	mock.ExpectQuery("SELECT table_name, schema_name, database_name, SUM\\(credits_used\\)").
+		WillReturnRows(materializedViewRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("materialized_view_credits")
+
+	expected := `
+		# HELP snowflake_materialized_view_credits_used Credits used refreshing materialized views over the lookback window
+		# TYPE snowflake_materialized_view_credits_used gauge
+		snowflake_materialized_view_credits_used{database_name="ANALYTICS",schema_name="PUBLIC",table_name="SESSIONS_MV"} 2
+		snowflake_materialized_view_credits_used{database_name="SALES",schema_name="REPORTING",table_name="DAILY_REVENUE_MV"} 0.75
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_materialized_view_credits_used")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_PipeUsage(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	pipeUsageRows := sqlmock.NewRows([]string{"pipe_name", "total_credits", "bytes_inserted"}).
+		AddRow("RAW.EVENTS_PIPE", 0.42, 1073741824).
+		AddRow("RAW.ORDERS_PIPE", 0.05, nil)
+	mock.ExpectQuery("SELECT pipe_name, SUM\\(credits_used\\)").
+		WillReturnRows(pipeUsageRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("pipe_usage")
+
+	expected := `
+		# HELP snowflake_pipe_bytes_inserted_total Bytes inserted by Snowpipe over the lookback window
+		# TYPE snowflake_pipe_bytes_inserted_total gauge
+		snowflake_pipe_bytes_inserted_total{pipe_name="RAW.EVENTS_PIPE"} 1.073741824e+09
+		snowflake_pipe_bytes_inserted_total{pipe_name="RAW.ORDERS_PIPE"} 0
+		# HELP snowflake_pipe_credits_used Credits used by Snowpipe over the lookback window
+		# TYPE snowflake_pipe_credits_used gauge
+		snowflake_pipe_credits_used{pipe_name="RAW.EVENTS_PIPE"} 0.42
+		snowflake_pipe_credits_used{pipe_name="RAW.ORDERS_PIPE"} 0.05
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_pipe_credits_used", "snowflake_pipe_bytes_inserted_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_TaskHistory(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	taskHistoryRows := sqlmock.NewRows([]string{"name", "database_name", "schema_name", "state", "task_runs"}).
+		AddRow("LOAD_ORDERS", "SALES", "ETL", "SUCCEEDED", 22).
+		AddRow("LOAD_ORDERS", "SALES", "ETL", "FAILED", 2).
+		AddRow("REFRESH_DASHBOARD", "ANALYTICS", "PUBLIC", "SUCCEEDED", 24)
+	mock.ExpectQuery("SELECT name, database_name, schema_name, state, COUNT\\(\\*\\) as task_runs").
+		WillReturnRows(taskHistoryRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("task_history")
+
+	expected := `
+		# HELP snowflake_task_failures_total Failed task runs over the lookback window
+		# TYPE snowflake_task_failures_total gauge
+		snowflake_task_failures_total{database_name="ANALYTICS",name="REFRESH_DASHBOARD",schema_name="PUBLIC"} 0
+		snowflake_task_failures_total{database_name="SALES",name="LOAD_ORDERS",schema_name="ETL"} 2
+		# HELP snowflake_task_runs_total Task runs by final state over the lookback window
+		# TYPE snowflake_task_runs_total gauge
+		snowflake_task_runs_total{database_name="ANALYTICS",name="REFRESH_DASHBOARD",schema_name="PUBLIC",state="SUCCEEDED"} 24
+		snowflake_task_runs_total{database_name="SALES",name="LOAD_ORDERS",schema_name="ETL",state="FAILED"} 2
+		snowflake_task_runs_total{database_name="SALES",name="LOAD_ORDERS",schema_name="ETL",state="SUCCEEDED"} 22
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_task_runs_total", "snowflake_task_failures_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ParallelGroups(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Three slow queries take at least three delays when run one after the
+	// other, so finishing sooner shows they overlapped
+	const delay = 500 * time.Millisecond
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillDelayFor(delay).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillDelayFor(delay).
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}))
+	mock.ExpectQuery("SELECT warehouse_name, query_type, COUNT\\(\\*\\) as query_count").
+		WillDelayFor(delay).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "query_type", "query_count"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.enabledGroups["storage_bytes"] = true
+	collector.enabledGroups["query_count"] = true
+
+	start := time.Now()
+	_, err = collector.scrape(context.Background())
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, elapsed, delay)
+	assert.Less(t, elapsed, 3*delay)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_BackgroundRefresh(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The first refresh is slow; a Collect meanwhile must not wait for it
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillDelayFor(300 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 12, nil, nil, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.refreshInterval = 400 * time.Millisecond
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		collector.runRefresher(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Before the first refresh completes only snowflake_up 0 is served
+	start := time.Now()
+	expectedInitial := `
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 0
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expectedInitial),
+		"snowflake_up", "snowflake_warehouse_credits_used")
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// The snapshot is served once the refresh lands
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used") == 1
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 10.5, gaugeValue(t, collector, "snowflake_warehouse_credits_used"))
+
+	// The ticker refreshes it again without any further Collect
+	assert.Eventually(t, func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return gaugeValue(t, collector, "snowflake_warehouse_credits_used") == 12
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestSnowflakeMetricsCollector_BackgroundRefreshStops(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.refreshInterval = time.Hour
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		collector.runRefresher(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, 2*time.Second, 20*time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresher did not stop after the context was cancelled")
+	}
+}
+
+func TestSnowflakeMetricsCollector_RefreshJitter(t *testing.T) {
+	collector := newSnowflakeMetricsCollector(nil)
+	collector.refreshInterval = time.Minute
+
+	// Without jitter every refresh waits the interval
+	assert.Equal(t, time.Minute, collector.refreshDelay())
+
+	// With it the waits spread across the band around the interval
+	collector.refreshJitter = 0.2
+	delays := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		delay := collector.refreshDelay()
+		assert.GreaterOrEqual(t, delay, 48*time.Second)
+		assert.LessOrEqual(t, delay, 72*time.Second)
+		delays[delay] = true
+	}
+	assert.Greater(t, len(delays), 1)
+}
+
+// gaugeValue collects c and returns the value of the first sample of the
+// named metric.
+func gaugeValue(t *testing.T, c prometheus.Collector, name string) float64 {
+	t.Helper()
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name && len(family.GetMetric()) > 0 {
+			return family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestSnowflakeMetricsCollector_MultipleAccounts(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+
+	// Each account gets its own connection and collector
+	for account, credits := range map[string]float64{"prod": 42, "dev": 3.5} {
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+			WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+				AddRow("COMPUTE_WH", credits, nil, nil, nil))
+
+		collector := newSnowflakeMetricsCollector(db)
+		collector.enabledGroups = onlyGroup("warehouse_credits")
+		assert.NoError(t, labeledRegisterer(registry, prometheus.Labels{"account": account}).Register(collector))
+	}
+
+	// The same warehouse name in both accounts yields two distinct series
+	expected := `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{account="dev",size="",warehouse_name="COMPUTE_WH"} 3.5
+		snowflake_warehouse_credits_used{account="prod",size="",warehouse_name="COMPUTE_WH"} 42
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up{account="dev"} 1
+		snowflake_up{account="prod"} 1
+	`
+	err := testutil.GatherAndCompare(registry,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_up")
+	assert.NoError(t, err)
+}
+
+func TestSnowflakeMetricsCollector_AccountLabel(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		// Create a sqlmock database
+		db, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+			WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+				AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+		cfg := Config{
+			Connection:          connectionConfig{Account: "myorg-myaccount"},
+			DisableAccountLabel: disable,
+		}
+		collector := newSnowflakeMetricsCollector(db)
+		collector.enabledGroups = onlyGroup("warehouse_credits")
+
+		registry := prometheus.NewPedanticRegistry()
+		assert.NoError(t, labeledRegisterer(registry, cfg.metricLabels(cfg.Connection.Account)).Register(collector))
+
+		// The label is on by default and carries SNOWFLAKE_ACCOUNT
+		expected := `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{account="myorg-myaccount",size="",warehouse_name="COMPUTE_WH"} 10.5
+	`
+		if disable {
+			expected = `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 10.5
+	`
+		}
+		err = testutil.GatherAndCompare(registry,
+			strings.NewReader(expected),
+			"snowflake_warehouse_credits_used")
+		assert.NoError(t, err, "disable=%v", disable)
+	}
+}
+
+func TestSnowflakeMetricsCollector_ExtraLabels(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+	cfg := Config{
+		ExtraLabels:         map[string]string{"env": "prod", "team": "data"},
+		DisableAccountLabel: true,
+	}
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, labeledRegisterer(registry, cfg.metricLabels("myaccount")).Register(collector))
+
+	// Extra labels reach query metrics and the exporter's own metrics alike
+	expected := `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{env="prod",size="",team="data",warehouse_name="COMPUTE_WH"} 10.5
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up{env="prod",team="data"} 1
+	`
+	err = testutil.GatherAndCompare(registry,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_up")
+	assert.NoError(t, err)
+
+	// A label that clashes with a metric's own label is refused at startup
+	clashing := labeledRegisterer(prometheus.NewRegistry(), prometheus.Labels{"warehouse_name": "x"})
+	assert.Error(t, clashing.Register(newSnowflakeMetricsCollector(db)))
+}
+
+func TestSnowflakeMetricsCollector_ReplicationUsage(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// NULL sums are reported as zero
+	replicationRows := sqlmock.NewRows([]string{"database_name", "total_credits", "bytes_transferred"}).
+		AddRow("SALES", 1.25, 536870912).
+		AddRow("ANALYTICS", nil, 1024)
+	mock.ExpectQuery("SELECT database_name, SUM\\(credits_used\\) as total_credits, SUM\\(bytes_transferred\\)").
+		WillReturnRows(replicationRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("replication_usage")
+
+	expected := `
+		# HELP snowflake_replication_bytes_transferred_total Bytes transferred by database replication over the lookback window
+		# TYPE snowflake_replication_bytes_transferred_total gauge
+		snowflake_replication_bytes_transferred_total{database_name="ANALYTICS"} 1024
+		snowflake_replication_bytes_transferred_total{database_name="SALES"} 5.36870912e+08
+		# HELP snowflake_replication_credits_used Credits used by database replication over the lookback window
+		# TYPE snowflake_replication_credits_used gauge
+		snowflake_replication_credits_used{database_name="ANALYTICS"} 0
+		snowflake_replication_credits_used{database_name="SALES"} 1.25
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_replication_credits_used", "snowflake_replication_bytes_transferred_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ServerlessTaskCredits(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// NULL credits are reported as zero
+	serverlessTaskRows := sqlmock.NewRows([]string{"task_name", "database_name", "schema_name", "total_credits"}).
+		AddRow("HOURLY_ROLLUP", "ANALYTICS", "PUBLIC", 0.31).
+		AddRow("NIGHTLY_CLEANUP", "SALES", "ETL", nil)
+	mock.ExpectQuery("SELECT task_name, database_name, schema_name, SUM\\(credits_used\\)").
+		WillReturnRows(serverlessTaskRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("serverless_task_credits")
+
+	expected := `
+		# HELP snowflake_serverless_task_credits_used Credits used by serverless tasks over the lookback window
+		# TYPE snowflake_serverless_task_credits_used gauge
+		snowflake_serverless_task_credits_used{database_name="ANALYTICS",schema_name="PUBLIC",task_name="HOURLY_ROLLUP"} 0.31
+		snowflake_serverless_task_credits_used{database_name="SALES",schema_name="ETL",task_name="NIGHTLY_CLEANUP"} 0
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_serverless_task_credits_used")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueuedTime(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Times are reported by Snowflake in milliseconds
+	queuedTimeRows := sqlmock.NewRows([]string{"warehouse_name", "queued_provisioning_ms", "queued_overload_ms"}).
+		AddRow("COMPUTE_WH", 1500, 62000).
+		AddRow("REPORTING_WH", 250, nil)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(queued_provisioning_time\\)").
+		WillReturnRows(queuedTimeRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("queued_time")
+
+	expected := `
+		# HELP snowflake_query_queued_overload_seconds Time queries spent queued behind other queries over the lookback window
+		# TYPE snowflake_query_queued_overload_seconds gauge
+		snowflake_query_queued_overload_seconds{warehouse_name="COMPUTE_WH"} 62
+		snowflake_query_queued_overload_seconds{warehouse_name="REPORTING_WH"} 0
+		# HELP snowflake_query_queued_provisioning_seconds Time queries spent queued for warehouse provisioning over the lookback window
+		# TYPE snowflake_query_queued_provisioning_seconds gauge
+		snowflake_query_queued_provisioning_seconds{warehouse_name="COMPUTE_WH"} 1.5
+		snowflake_query_queued_provisioning_seconds{warehouse_name="REPORTING_WH"} 0.25
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_query_queued_provisioning_seconds", "snowflake_query_queued_overload_seconds")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_LastSuccessTimestamp(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.cacheTTL = 0
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	now := time.Unix(1700000000, 0)
+	collector.now = func() time.Time { return now }
+
+	expectTimestamp := func(value string) {
+		t.Helper()
+		expected := `
+		# HELP snowflake_last_scrape_success_timestamp_seconds Unix time of the last fully successful scrape of Snowflake, or 0 if none has succeeded
+		# TYPE snowflake_last_scrape_success_timestamp_seconds gauge
+		snowflake_last_scrape_success_timestamp_seconds ` + value + `
+	`
+		err := testutil.CollectAndCompare(collector,
+			strings.NewReader(expected),
+			"snowflake_last_scrape_success_timestamp_seconds")
+		assert.NoError(t, err)
+	}
+
+	// No scrape has succeeded yet
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("database connection error"))
+	expectTimestamp("0")
+
+	// A successful scrape records the clock
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+	expectTimestamp("1.7e+09")
+
+	// A later failure keeps the last success
+	now = now.Add(time.Minute)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("database connection error"))
+	expectTimestamp("1.7e+09")
+
+	// and the next success moves it forward
+	now = now.Add(time.Minute)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+	expectTimestamp("1.70000012e+09")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_WarehouseState(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// SHOW output has many more columns than are used, in Snowflake's order
+	warehouseRows := sqlmock.NewRows([]string{"name", "state", "type", "size", "running", "queued", "auto_suspend"}).
+		AddRow("COMPUTE_WH", "STARTED", "STANDARD", "X-Small", 2, 0, 60).
+		AddRow("REPORTING_WH", "SUSPENDED", "STANDARD", "Large", 0, 0, 600).
+		AddRow("ETL_WH", "RESIZING", "STANDARD", "Medium", 1, 0, 300)
+	mock.ExpectQuery("SHOW WAREHOUSES").
+		WillReturnRows(warehouseRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_state")
+
+	expected := `
+		# HELP snowflake_warehouse_running Whether the warehouse is running (1) or suspended (0)
+		# TYPE snowflake_warehouse_running gauge
+		snowflake_warehouse_running{size="Large",warehouse_name="REPORTING_WH"} 0
+		snowflake_warehouse_running{size="Medium",warehouse_name="ETL_WH"} 1
+		snowflake_warehouse_running{size="X-Small",warehouse_name="COMPUTE_WH"} 1
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_running")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_RunningWarehouses(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	warehouseRows := sqlmock.NewRows([]string{"name", "state", "type", "size"}).
+		AddRow("COMPUTE_WH", "STARTED", "STANDARD", "X-Small").
+		AddRow("REPORTING_WH", "SUSPENDED", "STANDARD", "Large").
+		AddRow("ETL_WH", "RESIZING", "STANDARD", "Medium").
+		AddRow("ADHOC_WH", "SUSPENDING", "STANDARD", "Small").
+		AddRow("BI_WH", "started", "STANDARD", "Medium")
+	mock.ExpectQuery("SHOW WAREHOUSES").
+		WillReturnRows(warehouseRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_state")
+
+	expected := `
+		# HELP snowflake_running_warehouses_total Number of warehouses in the account that are currently running
+		# TYPE snowflake_running_warehouses_total gauge
+		snowflake_running_warehouses_total 3
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_running_warehouses_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_WarehouseAutoSuspend(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// NEVER_WH has auto-suspend disabled, which SHOW reports as NULL, and
+	// BROKEN_WH keeps its auto_resume sample despite an unparsable
+	// auto_suspend
+	warehouseRows := sqlmock.NewRows([]string{"name", "state", "type", "size", "auto_suspend", "auto_resume", "comment"}).
+		AddRow("COMPUTE_WH", "STARTED", "STANDARD", "X-Small", "60", "true", "").
+		AddRow("NEVER_WH", "STARTED", "STANDARD", "Large", nil, "false", "").
+		AddRow("ZERO_WH", "SUSPENDED", "STANDARD", "Small", "0", "true", "").
+		AddRow("BROKEN_WH", "SUSPENDED", "STANDARD", "Small", "soon", "true", "")
+	mock.ExpectQuery("SHOW WAREHOUSES").
+		WillReturnRows(warehouseRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_state")
+
+	expected := `
+		# HELP snowflake_warehouse_auto_resume Whether the warehouse resumes automatically (1) or not (0)
+		# TYPE snowflake_warehouse_auto_resume gauge
+		snowflake_warehouse_auto_resume{warehouse_name="BROKEN_WH"} 1
+		snowflake_warehouse_auto_resume{warehouse_name="COMPUTE_WH"} 1
+		snowflake_warehouse_auto_resume{warehouse_name="NEVER_WH"} 0
+		snowflake_warehouse_auto_resume{warehouse_name="ZERO_WH"} 1
+		# HELP snowflake_warehouse_auto_suspend_seconds Idle time after which the warehouse suspends, 0 if it never auto-suspends
+		# TYPE snowflake_warehouse_auto_suspend_seconds gauge
+		snowflake_warehouse_auto_suspend_seconds{warehouse_name="COMPUTE_WH"} 60
+		snowflake_warehouse_auto_suspend_seconds{warehouse_name="NEVER_WH"} 0
+		snowflake_warehouse_auto_suspend_seconds{warehouse_name="ZERO_WH"} 0
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_auto_suspend_seconds", "snowflake_warehouse_auto_resume")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_state")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_WarehouseStateMissingColumn(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SHOW WAREHOUSES").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "size"}).AddRow("COMPUTE_WH", "X-Small"))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_state")
+
+	err = testutil.CollectAndCompare(collector, strings.NewReader(""), "snowflake_warehouse_running")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_state")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_UserCredits(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	userCreditsRows := sqlmock.NewRows([]string{"user_name", "estimated_credits"}).
+		AddRow("ETL_SERVICE", 18.75).
+		AddRow("ANALYST", 1.25).
+		AddRow(nil, 3)
+	mock.ExpectQuery("SELECT u.user_name, SUM\\(m.credits_used_compute").
+		WillReturnRows(userCreditsRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("user_credits")
+
+	expected := `
+		# HELP snowflake_user_credits_used Estimated warehouse credits used by user over the lookback window
+		# TYPE snowflake_user_credits_used gauge
+		snowflake_user_credits_used{user_name="ANALYST"} 1.25
+		snowflake_user_credits_used{user_name="ETL_SERVICE"} 18.75
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_user_credits_used")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_StorageBreakdown(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	storageRows := sqlmock.NewRows([]string{"database_name", "active_bytes", "time_travel_bytes", "failsafe_bytes"}).
+		AddRow("PROD_DB", 1024000, 256000, 128000).
+		AddRow("DEV_DB", 512000, nil, 0)
+	mock.ExpectQuery("SELECT table_catalog as database_name, SUM\\(active_bytes\\)").
+		WillReturnRows(storageRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("storage_breakdown")
+
+	expected := `
+		# HELP snowflake_active_bytes Bytes of active table storage by database
+		# TYPE snowflake_active_bytes gauge
+		snowflake_active_bytes{database_name="DEV_DB"} 512000
+		snowflake_active_bytes{database_name="PROD_DB"} 1.024e+06
+		# HELP snowflake_failsafe_bytes Bytes of Fail-safe storage by database
+		# TYPE snowflake_failsafe_bytes gauge
+		snowflake_failsafe_bytes{database_name="DEV_DB"} 0
+		snowflake_failsafe_bytes{database_name="PROD_DB"} 128000
+		# HELP snowflake_time_travel_bytes Bytes of Time Travel storage by database
+		# TYPE snowflake_time_travel_bytes gauge
+		snowflake_time_travel_bytes{database_name="DEV_DB"} 0
+		snowflake_time_travel_bytes{database_name="PROD_DB"} 256000
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_active_bytes", "snowflake_time_travel_bytes", "snowflake_failsafe_bytes")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_StageStorage(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT SUM\\(average_stage_bytes\\) as stage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"stage_bytes"}).AddRow(73400320))
+	// Before today's row is recorded the sum is NULL
+	mock.ExpectQuery("SELECT SUM\\(average_stage_bytes\\) as stage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"stage_bytes"}).AddRow(nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.cacheTTL = 0
+	collector.enabledGroups = onlyGroup("stage_storage")
+
+	expected := `
+		# HELP snowflake_stage_bytes Bytes stored in internal stages
+		# TYPE snowflake_stage_bytes gauge
+		snowflake_stage_bytes 7.340032e+07
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_stage_bytes")
+	assert.NoError(t, err)
+
+	err = testutil.CollectAndCompare(collector, strings.NewReader(""), "snowflake_stage_bytes")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_BytesSpilled(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	bytesSpilledRows := sqlmock.NewRows([]string{"warehouse_name", "bytes_spilled_local", "bytes_spilled_remote"}).
+		AddRow("COMPUTE_WH", 2048, 512).
+		AddRow("REPORTING_WH", nil, nil)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(bytes_spilled_to_local_storage\\)").
+		WillReturnRows(bytesSpilledRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("bytes_spilled")
+
+	expected := `
+		# HELP snowflake_bytes_spilled_local_total Bytes spilled to local storage by queries over the lookback window
+		# TYPE snowflake_bytes_spilled_local_total gauge
+		snowflake_bytes_spilled_local_total{warehouse_name="COMPUTE_WH"} 2048
+		snowflake_bytes_spilled_local_total{warehouse_name="REPORTING_WH"} 0
+		# HELP snowflake_bytes_spilled_remote_total Bytes spilled to remote storage by queries over the lookback window
+		# TYPE snowflake_bytes_spilled_remote_total gauge
+		snowflake_bytes_spilled_remote_total{warehouse_name="COMPUTE_WH"} 512
+		snowflake_bytes_spilled_remote_total{warehouse_name="REPORTING_WH"} 0
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_bytes_spilled_local_total", "snowflake_bytes_spilled_remote_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_SnowflakeVersion(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT CURRENT_VERSION\\(\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"CURRENT_VERSION()"}).AddRow("8.40.1"))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("snowflake_version")
+
+	expected := `
+		# HELP snowflake_version_info A metric with a constant '1' value labeled by the Snowflake version the account runs
+		# TYPE snowflake_version_info gauge
+		snowflake_version_info{version="8.40.1"} 1
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_version_info")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ConnectionStats(t *testing.T) {
+	// Create a sqlmock database
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	poolConfig{MaxOpenConns: 3, MaxIdleConns: 3}.apply(db)
+
+	// Hold two connections and return one of them to the pool
+	ctx := context.Background()
+	held, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	defer held.Close()
+	idle, err := db.Conn(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, idle.Close())
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.refreshInterval = time.Hour
+
+	expected := `
+		# HELP snowflake_db_in_use_connections Number of connections to Snowflake currently in use
+		# TYPE snowflake_db_in_use_connections gauge
+		snowflake_db_in_use_connections 1
+		# HELP snowflake_db_open_connections Number of open connections to Snowflake, both in use and idle
+		# TYPE snowflake_db_open_connections gauge
+		snowflake_db_open_connections 2
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_db_open_connections", "snowflake_db_in_use_connections")
+	assert.NoError(t, err)
+}
+
+func TestSnowflakeMetricsCollector_TableStorage(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The row limit is bound to the query
+	tableRows := sqlmock.NewRows([]string{"table_catalog", "table_schema", "table_name", "active_bytes"}).
+		AddRow("PROD_DB", "PUBLIC", "EVENTS", 4096000).
+		AddRow("PROD_DB", "PUBLIC", "USERS", 8192)
+	mock.ExpectQuery("SELECT table_catalog, table_schema, table_name, active_bytes .* LIMIT \\?").
+		WithArgs(2).
+		WillReturnRows(tableRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.tableStorageLimit = 2
+	collector.enabledGroups = onlyGroup("table_storage")
+
+	expected := `
+		# HELP snowflake_table_bytes Bytes of active storage by table
+		# TYPE snowflake_table_bytes gauge
+		snowflake_table_bytes{database_name="PROD_DB",schema_name="PUBLIC",table_name="EVENTS"} 4.096e+06
+		snowflake_table_bytes{database_name="PROD_DB",schema_name="PUBLIC",table_name="USERS"} 8192
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_table_bytes")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_OptInGroups(t *testing.T) {
+	// Create a mock database (not used in Describe)
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for _, name := range []string{"table_storage", "logins_by_client_ip", "remaining_balance", "clustering_depth"} {
+		// The group is off unless enabled explicitly
+		collector := newSnowflakeMetricsCollector(db)
+		var names []string
+		for _, group := range collector.enabledMetricGroups() {
+			names = append(names, group.name)
+		}
+		assert.NotContains(t, names, name)
+
+		collector.enabledGroups = map[string]bool{name: true}
+		names = nil
+		for _, group := range collector.enabledMetricGroups() {
+			names = append(names, group.name)
+		}
+		assert.Contains(t, names, name)
+	}
+}
+
+func TestSnowflakeMetricsCollector_FailedQueries(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	failedRows := sqlmock.NewRows([]string{"warehouse_name", "error_code", "failed_queries"}).
+		AddRow("COMPUTE_WH", "000604", 7).
+		AddRow("COMPUTE_WH", "002003", 2).
+		AddRow("REPORTING_WH", nil, 1)
+	mock.ExpectQuery("SELECT warehouse_name, error_code, COUNT\\(\\*\\) as failed_queries .* execution_status = 'FAIL'").
+		WillReturnRows(failedRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("failed_queries")
+
+	expected := `
+		# HELP snowflake_failed_queries_total Number of failed queries by error code over the lookback window
+		# TYPE snowflake_failed_queries_total gauge
+		snowflake_failed_queries_total{error_code="",warehouse_name="REPORTING_WH"} 1
+		snowflake_failed_queries_total{error_code="000604",warehouse_name="COMPUTE_WH"} 7
+		snowflake_failed_queries_total{error_code="002003",warehouse_name="COMPUTE_WH"} 2
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_failed_queries_total")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_WarehouseLoad(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	loadRows := sqlmock.NewRows([]string{"warehouse_name", "avg_running", "avg_queued"}).
+		AddRow("COMPUTE_WH", 2.5, 0.75).
+		AddRow("REPORTING_WH", 0.1, nil)
+	mock.ExpectQuery("SELECT warehouse_name, AVG\\(avg_running\\) as avg_running, AVG\\(avg_queued_load \\+ avg_queued_provisioning\\)").
+		WillReturnRows(loadRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_load")
+
+	expected := `
+		# HELP snowflake_warehouse_avg_queued Average number of queries queued on the warehouse over the lookback window
+		# TYPE snowflake_warehouse_avg_queued gauge
+		snowflake_warehouse_avg_queued{warehouse_name="COMPUTE_WH"} 0.75
+		snowflake_warehouse_avg_queued{warehouse_name="REPORTING_WH"} 0
+		# HELP snowflake_warehouse_avg_running Average number of queries running on the warehouse over the lookback window
+		# TYPE snowflake_warehouse_avg_running gauge
+		snowflake_warehouse_avg_running{warehouse_name="COMPUTE_WH"} 2.5
+		snowflake_warehouse_avg_running{warehouse_name="REPORTING_WH"} 0.1
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_avg_running", "snowflake_warehouse_avg_queued")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ActiveUsers(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT COUNT\\(DISTINCT user_name\\) as active_users\\s+FROM snowflake.account_usage.query_history").
+		WillReturnRows(sqlmock.NewRows([]string{"active_users"}).AddRow(37))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("active_users")
+
+	expected := `
+		# HELP snowflake_active_users Number of distinct users that ran queries over the lookback window
+		# TYPE snowflake_active_users gauge
+		snowflake_active_users 37
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_active_users")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueriesByStatus(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Unexpected and NULL statuses are summed under OTHER
+	statusRows := sqlmock.NewRows([]string{"warehouse_name", "execution_status", "query_count"}).
+		AddRow("COMPUTE_WH", "SUCCESS", 120).
+		AddRow("COMPUTE_WH", "FAIL", 4).
+		AddRow("COMPUTE_WH", "incident", 1).
+		AddRow("COMPUTE_WH", "RESTARTED", 2).
+		AddRow("COMPUTE_WH", nil, 3).
+		AddRow("REPORTING_WH", "SUCCESS", 42).
+		AddRow(nil, "SUCCESS", 9)
+	mock.ExpectQuery("SELECT warehouse_name, execution_status, COUNT\\(\\*\\) as query_count\\s+FROM snowflake.account_usage.query_history").
+		WillReturnRows(statusRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("queries_by_status")
+
+	expected := `
+		# HELP snowflake_queries_by_status Number of queries by execution status over the lookback window
+		# TYPE snowflake_queries_by_status gauge
+		snowflake_queries_by_status{execution_status="FAIL",warehouse_name="COMPUTE_WH"} 4
+		snowflake_queries_by_status{execution_status="INCIDENT",warehouse_name="COMPUTE_WH"} 1
+		snowflake_queries_by_status{execution_status="OTHER",warehouse_name="COMPUTE_WH"} 5
+		snowflake_queries_by_status{execution_status="SUCCESS",warehouse_name="COMPUTE_WH"} 120
+		snowflake_queries_by_status{execution_status="SUCCESS",warehouse_name="REPORTING_WH"} 42
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_queries_by_status")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_LoginsByClientIP(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	loginRows := sqlmock.NewRows([]string{"user_name", "client_ip", "logins"}).
+		AddRow("ETL_SERVICE", "10.0.0.5", 48).
+		AddRow("ANALYST", "203.0.113.7", 3).
+		AddRow("ANALYST", "198.51.100.23", 1).
+		AddRow("ANALYST", nil, 2)
+	mock.ExpectQuery("SELECT user_name, client_ip, COUNT\\(\\*\\) as logins\\s+FROM snowflake.account_usage.login_history\\s+WHERE is_success = 'YES'").
+		WillReturnRows(loginRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("logins_by_client_ip")
+
+	expected := `
+		# HELP snowflake_logins_by_client_ip Number of successful logins by user and client IP over the lookback window
+		# TYPE snowflake_logins_by_client_ip gauge
+		snowflake_logins_by_client_ip{client_ip="10.0.0.5",user_name="ETL_SERVICE"} 48
+		snowflake_logins_by_client_ip{client_ip="198.51.100.23",user_name="ANALYST"} 1
+		snowflake_logins_by_client_ip{client_ip="203.0.113.7",user_name="ANALYST"} 3
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_logins_by_client_ip")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_RemainingBalance(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	balanceRows := sqlmock.NewRows([]string{"currency", "capacity_balance"}).
+		AddRow("USD", 12500.75).
+		AddRow("EUR", nil)
+	mock.ExpectQuery("SELECT currency, capacity_balance\\s+FROM snowflake.organization_usage.remaining_balance_daily").
+		WillReturnRows(balanceRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("remaining_balance")
+
+	expected := `
+		# HELP snowflake_remaining_balance_credits Remaining capacity balance of the organization contract on the latest reported day
+		# TYPE snowflake_remaining_balance_credits gauge
+		snowflake_remaining_balance_credits{currency="USD"} 12500.75
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_remaining_balance_credits")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_RemainingBalanceNoAccess(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT currency, capacity_balance").
+		WillReturnError(&gosnowflake.SnowflakeError{
+			Number:  2003,
+			Message: "SQL compilation error: Object 'SNOWFLAKE.ORGANIZATION_USAGE.REMAINING_BALANCE_DAILY' does not exist or not authorized.",
+		})
+
+	var buf bytes.Buffer
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("remaining_balance")
+	collector.logger, err = newLogger(&buf, "warn", "json")
+	assert.NoError(t, err)
+
+	// The scrape still succeeds, without a balance or a permission error
+	expected := `
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 1
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_up", "snowflake_remaining_balance_credits", "snowflake_permission_error")
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_UsageSchema(t *testing.T) {
+	views := map[string]string{
+		"data_transfer":             "data_transfer_history",
+		"automatic_clustering":      "automatic_clustering_history",
+		"materialized_view_credits": "materialized_view_refresh_history",
+		"pipe_usage":                "pipe_usage_history",
+		"replication_usage":         "replication_usage_history",
+		"serverless_task_credits":   "serverless_task_history",
+		"stage_storage":             "stage_storage_usage_history",
+		"daily_credits":             "metering_daily_history",
+	}
+	for _, schema := range []string{"account_usage", "organization_usage"} {
+		for group, view := range views {
+			t.Run(schema+"/"+group, func(t *testing.T) {
+				// Create a sqlmock database
+				db, mock, err := sqlmock.New()
+				assert.NoError(t, err)
+				defer db.Close()
+
+				mock.ExpectQuery("FROM snowflake\\." + schema + "\\." + view + " ").
+					WillReturnRows(sqlmock.NewRows([]string{"unused"}))
+
+				collector := newSnowflakeMetricsCollector(db)
+				collector.enabledGroups = onlyGroup(group)
+				collector.usageSchema = schema
+
+				_, err = collector.scrape(context.Background())
+				assert.NoError(t, err)
+				assert.NoError(t, mock.ExpectationsWereMet())
+			})
+		}
+	}
+
+	// Groups without an organization-wide view keep reading account_usage
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mock.ExpectQuery("FROM snowflake\\.account_usage\\.database_storage_usage_history").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("storage_bytes")
+	collector.usageSchema = "organization_usage"
+	_, err = collector.scrape(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_DailyCredits(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	dailyRows := sqlmock.NewRows([]string{"service_type", "credits_used"}).
+		AddRow("WAREHOUSE_METERING", 42.5).
+		AddRow("PIPE", 1.25).
+		AddRow("AUTO_CLUSTERING", 0.75).
+		AddRow(nil, 3)
+	mock.ExpectQuery("SELECT service_type, SUM\\(credits_used\\) as credits_used\\s+FROM snowflake.account_usage.metering_daily_history\\s+WHERE usage_date = CURRENT_DATE\\(\\)").
+		WillReturnRows(dailyRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("daily_credits")
+
+	expected := `
+		# HELP snowflake_daily_credits_used Credits used today by service type
+		# TYPE snowflake_daily_credits_used gauge
+		snowflake_daily_credits_used{service_type="AUTO_CLUSTERING"} 0.75
+		snowflake_daily_credits_used{service_type="PIPE"} 1.25
+		snowflake_daily_credits_used{service_type="WAREHOUSE_METERING"} 42.5
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_daily_credits_used")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ClusteringDepth(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT SYSTEM\\$CLUSTERING_DEPTH\\(\\?\\) as clustering_depth").
+		WithArgs("PROD_DB.PUBLIC.ORDERS").
+		WillReturnRows(sqlmock.NewRows([]string{"clustering_depth"}).AddRow(3.25))
+	mock.ExpectQuery("SELECT SYSTEM\\$CLUSTERING_DEPTH\\(\\?\\) as clustering_depth").
+		WithArgs("PROD_DB.PUBLIC.EVENTS").
+		WillReturnRows(sqlmock.NewRows([]string{"clustering_depth"}).AddRow(12))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("clustering_depth")
+	collector.clusteringTables = []string{"PROD_DB.PUBLIC.ORDERS", "PROD_DB.PUBLIC.EVENTS"}
+
+	expected := `
+		# HELP snowflake_table_clustering_depth Average clustering depth of a table with a clustering key
+		# TYPE snowflake_table_clustering_depth gauge
+		snowflake_table_clustering_depth{database_name="PROD_DB",schema_name="PUBLIC",table_name="EVENTS"} 12
+		snowflake_table_clustering_depth{database_name="PROD_DB",schema_name="PUBLIC",table_name="ORDERS"} 3.25
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_table_clustering_depth")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ClusteringDepthFailure(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A table without a clustering key does not stop the others
+	mock.ExpectQuery("SELECT SYSTEM\\$CLUSTERING_DEPTH").
+		WithArgs("PROD_DB.PUBLIC.UNCLUSTERED").
+		WillReturnError(fmt.Errorf("Invalid clustering keys or table UNCLUSTERED is not clustered"))
+	mock.ExpectQuery("SELECT SYSTEM\\$CLUSTERING_DEPTH").
+		WithArgs("PROD_DB.PUBLIC.ORDERS").
+		WillReturnRows(sqlmock.NewRows([]string{"clustering_depth"}).AddRow(2))
+	mock.ExpectQuery("SELECT SYSTEM\\$CLUSTERING_DEPTH").
+		WithArgs("PROD_DB.PUBLIC.DROPPED").
+		WillReturnError(fmt.Errorf("Table DROPPED does not exist"))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("clustering_depth")
+	collector.clusteringTables = []string{"PROD_DB.PUBLIC.UNCLUSTERED", "PROD_DB.PUBLIC.ORDERS", "PROD_DB.PUBLIC.DROPPED"}
+
+	// The group fails with the first table's error
+	metrics, err := collector.scrape(context.Background())
+	assert.ErrorContains(t, err, "UNCLUSTERED is not clustered")
+	var names []string
+	for _, metric := range metrics {
+		names = append(names, metric.Desc().String())
+	}
+	assert.Contains(t, strings.Join(names, "\n"), `"snowflake_table_clustering_depth"`)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("clustering_depth")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_QueryCountByDatabase(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Queries outside a database context have a NULL database_name
+	databaseRows := sqlmock.NewRows([]string{"database_name", "query_count"}).
+		AddRow("PROD_DB", 120).
+		AddRow("ANALYTICS_DB", 35).
+		AddRow(nil, 8)
+	mock.ExpectQuery("SELECT database_name, COUNT\\(\\*\\) as query_count\\s+FROM snowflake.account_usage.query_history\\s+WHERE start_time > .+\\s+GROUP BY database_name").
+		WillReturnRows(databaseRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("query_count_by_database")
+
+	expected := `
+		# HELP snowflake_query_count_by_database Number of queries by database
+		# TYPE snowflake_query_count_by_database gauge
+		snowflake_query_count_by_database{database_name=""} 8
+		snowflake_query_count_by_database{database_name="ANALYTICS_DB"} 35
+		snowflake_query_count_by_database{database_name="PROD_DB"} 120
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_query_count_by_database")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_CompilationTime(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	compilationRows := sqlmock.NewRows([]string{"warehouse_name", "avg_compilation_ms"}).
+		AddRow("COMPUTE_WH", 250).
+		AddRow("REPORTING_WH", 1875.5).
+		AddRow("IDLE_WH", nil).
+		AddRow(nil, 100)
+	mock.ExpectQuery("SELECT warehouse_name, AVG\\(compilation_time\\) as avg_compilation_ms\\s+FROM snowflake.account_usage.query_history").
+		WillReturnRows(compilationRows)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("compilation_time")
+
+	expected := `
+		# HELP snowflake_query_compilation_time_seconds Average query compilation time over the lookback window
+		# TYPE snowflake_query_compilation_time_seconds gauge
+		snowflake_query_compilation_time_seconds{warehouse_name="COMPUTE_WH"} 0.25
+		snowflake_query_compilation_time_seconds{warehouse_name="REPORTING_WH"} 1.8755
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_query_compilation_time_seconds")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
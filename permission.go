@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// permissionErrorCodes are Snowflake error numbers returned when the role
+// lacks access to an object. Snowflake reports an object the role cannot see
+// as not existing, so the two cannot be told apart.
+var permissionErrorCodes = map[int]bool{
+	2003: true, // object does not exist or not authorized
+	3001: true, // insufficient privileges
+}
+
+// isPermissionError reports whether err is Snowflake refusing access.
+func isPermissionError(err error) bool {
+	var sfErr *gosnowflake.SnowflakeError
+	return errors.As(err, &sfErr) && permissionErrorCodes[sfErr.Number]
+}
+
+// viewRE matches the Snowflake views the built-in queries read from.
+var viewRE = regexp.MustCompile(`(?i)\bsnowflake\.(account_usage|organization_usage)\.\w+`)
+
+// queryView names the view a query reads from, falling back to the group
+// name for queries such as SHOW commands that read no view.
+func queryView(group, query string) string {
+	if view := viewRE.FindString(query); view != "" {
+		return strings.ToLower(view)
+	}
+	return group
+}
+
+// optionalViewGroups are the groups reading a view most accounts cannot
+// see, such as organization_usage outside the organization's ORGADMIN
+// account. Being refused access there is expected, so it is neither warned
+// about nor reported as a permission error.
+var optionalViewGroups = map[string]bool{
+	"remaining_balance": true,
+}
+
+// permissionTracker remembers the views the exporter's role cannot read,
+// warning once per view with the grant that fixes it.
+type permissionTracker struct {
+	mu     sync.Mutex
+	denied map[string]bool
+	warned map[string]bool
+}
+
+// recordPermission updates the state of the view a query reads from its
+// outcome. Other errors leave the state unchanged, as they say nothing about
+// access, as do refusals for the groups in optionalViewGroups.
+func (c *SnowflakeMetricsCollector) recordPermission(group, query string, err error) {
+	if optionalViewGroups[group] && isPermissionError(err) {
+		return
+	}
+	view := queryView(group, query)
+	p := &c.permissions
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err == nil:
+		delete(p.denied, view)
+	case isPermissionError(err):
+		if p.denied == nil {
+			p.denied, p.warned = map[string]bool{}, map[string]bool{}
+		}
+		p.denied[view] = true
+		if !p.warned[view] {
+			p.warned[view] = true
+			c.logger.Warn("Missing privileges to read Snowflake view; grant them with GRANT IMPORTED PRIVILEGES ON DATABASE SNOWFLAKE TO ROLE <exporter role>",
+				"query", group, "view", view, "err", err)
+		}
+	}
+}
+
+// collectPermissionErrors emits a series for each view whose last query
+// failed for lack of privileges.
+func (c *SnowflakeMetricsCollector) collectPermissionErrors(ch chan<- prometheus.Metric) {
+	p := &c.permissions
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for view := range p.denied {
+		ch <- prometheus.MustNewConstMetric(c.permissionError, prometheus.GaugeValue, 1, view)
+	}
+}
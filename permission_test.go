@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/snowflakedb/gosnowflake"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflakeMetricsCollector_PermissionError(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	denied := &gosnowflake.SnowflakeError{
+		Number:  2003,
+		Message: "SQL compilation error: Object 'SNOWFLAKE.ACCOUNT_USAGE.DATABASE_STORAGE_USAGE_HISTORY' does not exist or not authorized.",
+	}
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT database_name, storage_bytes").WillReturnError(denied)
+	}
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}))
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "json")
+	assert.NoError(t, err)
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.cacheTTL = 0
+	collector.logger = logger
+	collector.enabledGroups = onlyGroup("storage_bytes")
+
+	expected := `
+		# HELP snowflake_permission_error Whether the last query of a view failed because the role lacks privileges on it
+		# TYPE snowflake_permission_error gauge
+		snowflake_permission_error{view="snowflake.account_usage.database_storage_usage_history"} 1
+	`
+	for i := 0; i < 2; i++ {
+		err = testutil.CollectAndCompare(collector, strings.NewReader(expected), "snowflake_permission_error")
+		assert.NoError(t, err)
+	}
+
+	// The grant is explained once, not on every scrape
+	var warnings []map[string]interface{}
+	for _, record := range logRecords(t, buf.String()) {
+		if record["level"] == "WARN" {
+			warnings = append(warnings, record)
+		}
+	}
+	if assert.Len(t, warnings, 1) {
+		assert.Contains(t, warnings[0]["msg"], "GRANT IMPORTED PRIVILEGES ON DATABASE SNOWFLAKE")
+		assert.Equal(t, "snowflake.account_usage.database_storage_usage_history", warnings[0]["view"])
+	}
+
+	// Once the grant is in place the series goes away
+	err = testutil.CollectAndCompare(collector, strings.NewReader(""), "snowflake_permission_error")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryView(t *testing.T) {
+	assert.Equal(t, "snowflake.account_usage.query_history",
+		queryView("query_count", "SELECT COUNT(*) FROM SNOWFLAKE.ACCOUNT_USAGE.QUERY_HISTORY WHERE 1=1"))
+	assert.Equal(t, "warehouse_state", queryView("warehouse_state", "SHOW WAREHOUSES"))
+}
+
+func TestIsPermissionError(t *testing.T) {
+	assert.True(t, isPermissionError(&gosnowflake.SnowflakeError{Number: 2003}))
+	assert.True(t, isPermissionError(&gosnowflake.SnowflakeError{Number: 3001}))
+	assert.False(t, isPermissionError(&gosnowflake.SnowflakeError{Number: 1003}))
+	assert.False(t, isPermissionError(assert.AnError))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// conn returns the current database handle.
+func (c *SnowflakeMetricsCollector) conn() *sql.DB {
+	c.dbMu.RLock()
+	defer c.dbMu.RUnlock()
+	return c.db
+}
+
+// PingContext checks that the current connection is alive.
+func (c *SnowflakeMetricsCollector) PingContext(ctx context.Context) error {
+	return c.conn().PingContext(ctx)
+}
+
+// Close closes the current connection.
+func (c *SnowflakeMetricsCollector) Close() error {
+	return c.conn().Close()
+}
+
+// isConnectionError reports whether err suggests the connection itself has
+// gone bad rather than the query failing.
+func isConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var sfErr *gosnowflake.SnowflakeError
+	if errors.As(err, &sfErr) {
+		return sessionErrorCodes[sfErr.Number]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
+
+// reconnect replaces the connection when it no longer answers a ping.
+// Concurrent callers wait on reconnectMu and then find the replacement
+// answering, so they reconnect at most once. The ping and the open run
+// without dbMu, so a hung connection never blocks conn; dbMu is only taken to
+// swap the handle. The old handle is closed afterwards, which waits for the
+// queries still running on it.
+func (c *SnowflakeMetricsCollector) reconnect() {
+	if c.open == nil {
+		return
+	}
+
+	c.reconnectMu.Lock()
+	defer c.reconnectMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.queryTimeout)
+	defer cancel()
+	if err := c.conn().PingContext(ctx); err == nil {
+		return
+	}
+
+	db, err := c.open()
+	if err != nil {
+		c.logger.Error("Failed to reconnect to Snowflake", "err", err)
+		return
+	}
+	c.logger.Warn("Reconnected to Snowflake after a connection error")
+	c.dbMu.Lock()
+	old := c.db
+	c.db = db
+	c.dbMu.Unlock()
+	old.Close()
+	c.reconnects.Inc()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/snowflakedb/gosnowflake"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflakeMetricsCollector_Reconnect(t *testing.T) {
+	// The original connection loses its session and stops answering pings
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(&gosnowflake.SnowflakeError{Number: gosnowflake.ErrSessionGone, Message: "Session no longer exists"})
+	mock.ExpectPing().WillReturnError(fmt.Errorf("session gone"))
+	mock.ExpectClose()
+
+	// The replacement connection works
+	newDB, newMock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer newDB.Close()
+	newMock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.queryRetries = 0
+	collector.cacheTTL = 0
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.open = func() (*sql.DB, error) {
+		return newDB, nil
+	}
+
+	// The failing scrape swaps the connection
+	_, err = collector.scrape(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.reconnects))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// and the next one succeeds on the new connection
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "snowflake_warehouse_credits_used"))
+	assert.NoError(t, newMock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ReconnectSkippedWhenAlive(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The session error was transient and the connection still answers
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(&gosnowflake.SnowflakeError{Number: 390112, Message: "Session expired"})
+	mock.ExpectPing()
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.queryRetries = 0
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.open = func() (*sql.DB, error) {
+		t.Fatal("a live connection must not be replaced")
+		return nil, nil
+	}
+
+	_, err = collector.scrape(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(collector.reconnects))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_NoReconnectOnQueryError(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A SQL error says nothing about the connection, so it is not pinged
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(&gosnowflake.SnowflakeError{Number: 2003, Message: "Object does not exist or not authorized"})
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.open = func() (*sql.DB, error) {
+		t.Fatal("a SQL error must not trigger a reconnect")
+		return nil, nil
+	}
+
+	_, err = collector.scrape(context.Background())
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ReconnectDoesNotBlockConn(t *testing.T) {
+	// The original connection hangs on the ping
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	mock.ExpectPing().WillDelayFor(500 * time.Millisecond).WillReturnError(fmt.Errorf("session gone"))
+	mock.ExpectClose()
+
+	newDB, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer newDB.Close()
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.open = func() (*sql.DB, error) {
+		return newDB, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		collector.reconnect()
+		close(done)
+	}()
+
+	// The handle stays readable while the ping is outstanding
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	assert.Same(t, db, collector.conn())
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	<-done
+	assert.Same(t, newDB, collector.conn())
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.reconnects))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registration is an account collector together with the labels added to
+// its metrics when it is served.
+type registration struct {
+	collector *SnowflakeMetricsCollector
+	labels    prometheus.Labels
+}
+
+// watchReloads applies every configuration received on reloads to the
+// collectors until ctx is cancelled.
+func watchReloads(ctx context.Context, cfg Config, regs []registration, reloads <-chan Config) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case next := <-reloads:
+			cfg = reload(cfg, next, regs)
+		}
+	}
+}
+
+// reloadable names, by YAML key, the settings a reload applies to the
+// running collectors. Changing any other setting, such as the connection or
+// the listen address, needs a restart.
+var reloadable = map[string]bool{
+	"cache_ttl":              true,
+	"cache_ttls":             true,
+	"clustering_tables":      true,
+	"custom_metrics":         true,
+	"lookback":               true,
+	"max_concurrent_queries": true,
+	"metric_groups":          true,
+	"queries":                true,
+	"query_count_by_user":    true,
+	"query_retries":          true,
+	"query_timeout":          true,
+	"refresh_jitter":         true,
+	"scrape_timeout":         true,
+	"table_storage_limit":    true,
+	"usage_schema":           true,
+	"warehouse_filter":       true,
+}
+
+// reload applies the reloadable settings of next to every collector and
+// returns the configuration now in effect. Other changed settings are
+// ignored with a warning and keep their startup values until a restart.
+func reload(current, next Config, regs []registration) Config {
+	current, restart := mergeReload(current, next)
+	for _, setting := range restart {
+		slog.Warn("Ignoring configuration change that requires a restart", "setting", setting)
+	}
+
+	// Each request registers the collectors afresh, so the metrics they
+	// describe follow the newly enabled groups
+	for _, reg := range regs {
+		reg.collector.configure(current)
+	}
+	slog.Info("Reloaded configuration", "lookback", current.lookback, "cache_ttl", current.CacheTTL)
+	return current
+}
+
+// mergeReload returns current with the reloadable settings of next, along
+// with the YAML keys of the other settings that differ between the two.
+func mergeReload(current, next Config) (Config, []string) {
+	var restart []string
+	merged := reflect.ValueOf(&current).Elem()
+	proposed := reflect.ValueOf(next)
+	for i := 0; i < merged.NumField(); i++ {
+		field := merged.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if reflect.DeepEqual(merged.Field(i).Interface(), proposed.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !reloadable[name] {
+			restart = append(restart, name)
+			continue
+		}
+		merged.Field(i).Set(proposed.Field(i))
+	}
+	// The parsed lookback follows its setting
+	current.lookback = next.lookback
+	return current, restart
+}
+
+// configure switches the collector to the reloadable settings of cfg,
+// waiting for a scrape in progress to finish first. Cached results are
+// dropped so the next scrape queries Snowflake with the new settings.
+func (c *SnowflakeMetricsCollector) configure(cfg Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scrapeMu.Lock()
+	defer c.scrapeMu.Unlock()
+	c.groupsMu.Lock()
+	defer c.groupsMu.Unlock()
+
+	c.enabledGroups = cfg.MetricGroups
+	c.cacheTTL = cfg.CacheTTL
+	c.groupTTLs = cfg.CacheTTLs
+	c.lookback = cfg.lookback
+	c.refreshJitter = cfg.RefreshJitter
+	c.queryTimeout = cfg.QueryTimeout
+	c.scrapeTimeout = cfg.ScrapeTimeout
+	c.queryRetries = cfg.QueryRetries
+	c.querySlots = make(chan struct{}, cfg.MaxConcurrentQueries)
+	c.warehouseFilter = cfg.WarehouseFilter
+	c.tableStorageLimit = cfg.TableStorageLimit
+	c.clusteringTables = cfg.ClusteringTables
+	c.usageSchema = cfg.UsageSchema
+	c.queryCountByUser = cfg.QueryCountByUser
+	c.queryOverrides = cfg.Queries
+	c.customMetrics = loadCustomMetrics(cfg.CustomMetrics)
+	c.groupCache = nil
+	c.expires = time.Time{}
+}
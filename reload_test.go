@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchReloads(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "warn", "json")
+	assert.NoError(t, err)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	current := Config{
+		Connection:   connectionConfig{Account: "myaccount"},
+		ListenPort:   defaultPort,
+		CacheTTL:     time.Hour,
+		QueryTimeout: defaultQueryTimeout,
+		MetricGroups: onlyGroup("warehouse_credits"),
+		UsageSchema:  defaultUsageSchema,
+		lookback:     24 * time.Hour,
+
+		MaxConcurrentQueries: defaultMaxConcurrentQueries,
+		TableStorageLimit:    defaultTableStorageLimit,
+	}
+	collector := newSnowflakeMetricsCollector(db)
+	collector.configure(current)
+	regs := []registration{{collector, nil}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan Config)
+	done := make(chan struct{})
+	go func() {
+		watchReloads(ctx, current, regs, reloads)
+		close(done)
+	}()
+
+	// The reload switches groups, clustering tables and lookback, while the
+	// account and port changes need a restart and are left alone
+	next := current
+	next.Connection = connectionConfig{Account: "otheraccount"}
+	next.ListenPort = "9100"
+	next.MetricGroups = onlyGroup("failed_queries")
+	next.MetricGroups["clustering_depth"] = true
+	next.ClusteringTables = []string{"PROD_DB.PUBLIC.ORDERS"}
+	next.Lookback = "6h"
+	next.lookback = 6 * time.Hour
+	reloads <- next
+	cancel()
+	<-done
+
+	// The newly enabled groups are queried with the new lookback and tables,
+	// despite the cache TTL, and described to the registry of the next
+	// request
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT SYSTEM\\$CLUSTERING_DEPTH").
+		WithArgs("PROD_DB.PUBLIC.ORDERS").
+		WillReturnRows(sqlmock.NewRows([]string{"clustering_depth"}).AddRow(2.5))
+	mock.ExpectQuery("SELECT warehouse_name, error_code, COUNT\\(\\*\\) as failed_queries .* dateadd\\(hour, -6, current_timestamp\\(\\)\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "error_code", "failed_queries"}).
+			AddRow("COMPUTE_WH", "000604", 7))
+	gatherer, err := requestGatherer(context.Background(), prometheus.NewRegistry(), regs)
+	assert.NoError(t, err)
+	count, err := testutil.GatherAndCount(gatherer, "snowflake_failed_queries_total", "snowflake_table_clustering_depth", "snowflake_warehouse_credits_used")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var warned []interface{}
+	for _, record := range logRecords(t, buf.String()) {
+		if record["level"] == "WARN" {
+			warned = append(warned, record["setting"])
+		}
+	}
+	assert.Equal(t, []interface{}{"connection", "listen_port"}, warned)
+}
+
+func TestReload_ConcurrentRequests(t *testing.T) {
+	// Create a sqlmock database
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Serving the background snapshot keeps the requests off the database,
+	// while every request still describes the collector afresh
+	current := Config{MetricGroups: onlyGroup("query_count")}
+	collector := newSnowflakeMetricsCollector(db)
+	collector.configure(current)
+	collector.refreshInterval = time.Hour
+	regs := []registration{{collector, nil}}
+	registry := prometheus.NewRegistry()
+	handler := metricsHandler(registry, registry, regs, false)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		next := current
+		next.QueryCountByUser = i%2 == 0
+		next.CustomMetrics = []CustomMetric{warehouseQueueMetric}[:i%2]
+		current = reload(current, next, regs)
+	}
+	<-done
+}
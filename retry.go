@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// sessionErrorCodes are Snowflake error numbers for expired or lost sessions.
+var sessionErrorCodes = map[int]bool{
+	gosnowflake.ErrSessionGone: true, // 390111
+	390112:                     true, // session expired
+	390114:                     true, // authentication token expired
+}
+
+// transientErrorCodes are Snowflake error numbers worth retrying: session
+// errors, which the driver re-establishes on the next attempt, and the
+// service being briefly unavailable.
+var transientErrorCodes = map[int]bool{
+	gosnowflake.ErrSessionGone:            true,
+	390112:                                true,
+	390114:                                true,
+	gosnowflake.ErrCodeServiceUnavailable: true, // 260007
+}
+
+// isTransient reports whether err is a failure that may succeed when the
+// query is retried. SQL errors such as syntax or permission errors are not,
+// nor is running out of time.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var sfErr *gosnowflake.SnowflakeError
+	if errors.As(err, &sfErr) {
+		return transientErrorCodes[sfErr.Number]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// query runs a metric group's query, retrying transient failures up to
+// queryRetries times with exponential backoff. Retries stop once ctx is done.
+// A configured override replaces query and is run without args. Each attempt
+// first waits for a free query slot, and the wait counts against ctx.
+func (c *SnowflakeMetricsCollector) query(ctx context.Context, group, query string, args ...interface{}) (*sql.Rows, error) {
+	if custom, ok := c.queryOverrides[group]; ok {
+		query, args = custom, nil
+	}
+
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		rows, err := c.queryOnce(ctx, query, args...)
+		if err == nil || attempt >= c.queryRetries || !isTransient(err) {
+			c.recordPermission(group, query, err)
+			return rows, err
+		}
+
+		c.logger.Warn("Retrying query after transient error", "query", group, "attempt", attempt+1, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// queryOnce runs query while holding one of the collector's query slots.
+func (c *SnowflakeMetricsCollector) queryOnce(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	select {
+	case c.querySlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-c.querySlots }()
+	return c.conn().QueryContext(ctx, query, args...)
+}
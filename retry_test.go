@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/snowflakedb/gosnowflake"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&gosnowflake.SnowflakeError{Number: 390114, Message: "Authentication token has expired"}, true},
+		{&gosnowflake.SnowflakeError{Number: gosnowflake.ErrSessionGone}, true},
+		{fmt.Errorf("query failed: %w", &gosnowflake.SnowflakeError{Number: 390112}), true},
+		{driver.ErrBadConn, true},
+		{&gosnowflake.SnowflakeError{Number: 1003, Message: "SQL compilation error: syntax error"}, false},
+		{&gosnowflake.SnowflakeError{Number: 2003, Message: "Object does not exist or not authorized"}, false},
+		{context.DeadlineExceeded, false},
+		{fmt.Errorf("database connection error"), false},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, isTransient(tc.err), "%v", tc.err)
+	}
+}
+
+func TestSnowflakeMetricsCollector_RetryTransientError(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The first attempt fails with an expired session, the retry succeeds
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(&gosnowflake.SnowflakeError{Number: 390114, Message: "Authentication token has expired"})
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.retryBackoff = time.Millisecond
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	expected := `
+		# HELP snowflake_warehouse_credits_used Number of credits used by warehouse
+		# TYPE snowflake_warehouse_credits_used gauge
+		snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 10.5
+		# HELP snowflake_up Whether the last scrape of Snowflake succeeded
+		# TYPE snowflake_up gauge
+		snowflake_up 1
+	`
+	err = testutil.CollectAndCompare(collector,
+		strings.NewReader(expected),
+		"snowflake_warehouse_credits_used", "snowflake_up")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_credits")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_RetryGivesUp(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// One attempt plus two retries, then the error is reported
+	for i := 0; i < 3; i++ {
+		mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+			WillReturnError(&net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")})
+	}
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.queryRetries = 2
+	collector.retryBackoff = time.Millisecond
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	_, err = collector.scrape(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.scrapeErrors.WithLabelValues("warehouse_credits")))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_NoRetryOnSQLError(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// A syntax error is returned after a single attempt
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(&gosnowflake.SnowflakeError{Number: 1003, Message: "SQL compilation error: syntax error"})
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.retryBackoff = time.Millisecond
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+
+	_, err = collector.scrape(context.Background())
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_MaxConcurrentQueries(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Four slow groups share two query slots, so they run in two waves
+	mock.MatchExpectationsInOrder(false)
+	groups := []string{"storage_breakdown", "stage_storage", "snowflake_version", "warehouse_state"}
+	for _, query := range []string{"SUM\\(active_bytes\\)", "SUM\\(average_stage_bytes\\)", "CURRENT_VERSION", "SHOW WAREHOUSES"} {
+		mock.ExpectQuery(query).
+			WillDelayFor(100 * time.Millisecond).
+			WillReturnError(fmt.Errorf("done"))
+	}
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.querySlots = make(chan struct{}, 2)
+	collector.enabledGroups = onlyGroup("")
+	for _, group := range groups {
+		collector.enabledGroups[group] = true
+	}
+
+	// Track the most queries seen in flight at once
+	stop := make(chan struct{})
+	peak := make(chan int)
+	go func() {
+		max := 0
+		for {
+			select {
+			case <-stop:
+				peak <- max
+				return
+			default:
+				if n := len(collector.querySlots); n > max {
+					max = n
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	start := time.Now()
+	_, err = collector.scrape(context.Background())
+	elapsed := time.Since(start)
+	close(stop)
+
+	assert.Error(t, err)
+	assert.Equal(t, 2, <-peak)
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"html"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// readyTimeout bounds the Snowflake ping behind /ready so a slow or
+// unreachable account fails the probe instead of hanging it.
+const readyTimeout = 5 * time.Second
+
+// landingPage is served at / so that a browser pointed at the exporter finds
+// the metrics route. %s is replaced with the metrics path.
+const landingPage = `<html>
+<head><title>Snowflake Exporter</title></head>
+<body>
+<h1>Snowflake Exporter</h1>
+<p><a href="%s">Metrics</a></p>
+</body>
+</html>
+`
+
+// newHandler builds the exporter's HTTP routes: metrics at cfg.MetricsPath
+// behind optional basic auth, the probe endpoints, and a landing page at /.
+func newHandler(cfg Config, metrics http.Handler, dbs ...pinger) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.MetricsPath, basicAuth(metrics, cfg.MetricsAuthUsername, cfg.MetricsAuthPassword))
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/ready", readyHandler(readyTimeout, dbs...))
+	if cfg.MetricsPath != "/" {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, landingPage, html.EscapeString(cfg.MetricsPath))
+		})
+	}
+	return mux
+}
+
+// newServer returns the HTTP server for handler, listening on the configured
+// address with the configured timeouts.
+func newServer(cfg Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         cfg.listenAddress(),
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+}
+
+// newRegistry returns the registry the exporter's metrics are served from.
+// Unless disabled it holds the Go runtime and process collectors, so the
+// exporter's own goroutines, memory and GC can be monitored too.
+func newRegistry(cfg Config) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	if !cfg.DisableRuntimeMetrics {
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+	return registry
+}
+
+// contextCollector collects an account collector under the context of the
+// metrics request being served, so a scrape the client gives up on stops
+// too. prometheus.Collector has no way to pass a context, so one is created
+// for every request.
+type contextCollector struct {
+	*SnowflakeMetricsCollector
+	ctx context.Context
+}
+
+// Collect implements prometheus.Collector.
+func (cc contextCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.collectContext(cc.ctx, ch)
+}
+
+// requestGatherer returns a gatherer for the metrics of gatherer and of the
+// account collectors in regs, which are registered on a registry of their
+// own with their queries running under ctx.
+func requestGatherer(ctx context.Context, gatherer prometheus.Gatherer, regs []registration) (prometheus.Gatherer, error) {
+	registry := prometheus.NewRegistry()
+	for _, reg := range regs {
+		if err := labeledRegisterer(registry, reg.labels).Register(contextCollector{reg.collector, ctx}); err != nil {
+			return nil, err
+		}
+	}
+	return prometheus.Gatherers{gatherer, registry}, nil
+}
+
+// metricsHandler serves the metrics gathered from gatherer and from the
+// account collectors in regs, the latter under the context of each request,
+// instrumented with the promhttp handler metrics registered on reg. With
+// gzip set responses are gzipped for clients that accept it; otherwise they
+// are never compressed.
+func metricsHandler(reg prometheus.Registerer, gatherer prometheus.Gatherer, regs []registration, gzip bool) http.Handler {
+	opts := promhttp.HandlerOpts{DisableCompression: !gzip}
+	if gzip {
+		opts.OfferedCompressions = []promhttp.Compression{promhttp.Identity, promhttp.Gzip}
+	}
+	return promhttp.InstrumentMetricHandler(reg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g, err := requestGatherer(r.Context(), gatherer, regs)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to register collectors: %v", err), http.StatusInternalServerError)
+			return
+		}
+		promhttp.HandlerFor(g, opts).ServeHTTP(w, r)
+	}))
+}
+
+// healthHandler reports that the process is alive. It never touches
+// Snowflake, so a degraded account does not get the exporter restarted.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK\n"))
+}
+
+// pinger is a connection that can be health checked, such as *sql.DB.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// readyHandler returns a handler that reports ready only while every db
+// answers a ping within timeout.
+func readyHandler(timeout time.Duration, dbs ...pinger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		for _, db := range dbs {
+			if err := db.PingContext(ctx); err != nil {
+				slog.Warn("Readiness check failed", "err", err)
+				http.Error(w, "Snowflake unreachable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK\n"))
+	}
+}
+
+// basicAuth wraps next so that requests must carry the given basic auth
+// credentials. next is returned unchanged when no credentials are configured.
+func basicAuth(next http.Handler, username, password string) http.Handler {
+	if username == "" && password == "" {
+		return next
+	}
+
+	// Compare digests so the comparison takes the same time regardless of
+	// how much of the supplied credentials match, including their length.
+	wantUser := sha256.Sum256([]byte(username))
+	wantPass := sha256.Sum256([]byte(password))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		gotUser := sha256.Sum256([]byte(user))
+		gotPass := sha256.Sum256([]byte(pass))
+		userMatch := subtle.ConstantTimeCompare(gotUser[:], wantUser[:])
+		passMatch := subtle.ConstantTimeCompare(gotPass[:], wantPass[:])
+		if !ok || userMatch&passMatch != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="snowflake-exporter", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serve runs server on listener until ctx is cancelled and then shuts it down
+// gracefully. The server speaks HTTPS when certFile and keyFile are set and
+// plain HTTP otherwise.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, certFile, keyFile string) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		if certFile != "" && keyFile != "" {
+			serveErr <- server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down Snowflake Prometheus Exporter")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %v", err)
+	}
+	return nil
+}
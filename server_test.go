@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestReadyHandler_Reachable(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPing()
+
+	rec := httptest.NewRecorder()
+	readyHandler(time.Second, db)(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadyHandler_Unreachable(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+
+	rec := httptest.NewRecorder()
+	readyHandler(time.Second, db)(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReadyHandler_Timeout(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPing().WillDelayFor(time.Second)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	readyHandler(50*time.Millisecond, db)(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestBasicAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := basicAuth(ok, "prometheus", "s3cret")
+
+	tests := map[string]struct {
+		user, pass string
+		setAuth    bool
+		want       int
+	}{
+		"correct credentials": {"prometheus", "s3cret", true, http.StatusOK},
+		"wrong password":      {"prometheus", "wrong", true, http.StatusUnauthorized},
+		"wrong username":      {"admin", "s3cret", true, http.StatusUnauthorized},
+		"no credentials":      {"", "", false, http.StatusUnauthorized},
+	}
+
+	for name, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.setAuth {
+			req.SetBasicAuth(tc.user, tc.pass)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.want, rec.Code, name)
+		if tc.want == http.StatusUnauthorized {
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Basic", name)
+		}
+	}
+}
+
+func TestBasicAuth_NotConfigured(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	basicAuth(ok, "", "").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestNewHandler_CustomMetricsPath(t *testing.T) {
+	// Create a sqlmock database
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("snowflake_up 1\n"))
+	})
+	handler := newHandler(Config{MetricsPath: "/snowflake/metrics"}, metrics, db)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/snowflake/metrics")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "snowflake_up 1")
+
+	assert.Equal(t, http.StatusNotFound, get("/metrics").Code)
+
+	// The landing page links to the configured path
+	rec = get("/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `href="/snowflake/metrics"`)
+
+	assert.Equal(t, http.StatusOK, get("/health").Code)
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key to
+// a temporary directory and returns their paths along with the certificate.
+func writeTestCert(t *testing.T) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "snowflake-exporter-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cert, certFile, keyFile
+}
+
+func TestNewServer_Timeouts(t *testing.T) {
+	t.Setenv("EXPORTER_WRITE_TIMEOUT", "90s")
+
+	cfg, err := LoadConfig("")
+	assert.NoError(t, err)
+	cfg.ListenAddress = "127.0.0.1:9091"
+
+	handler := http.NotFoundHandler()
+	server := newServer(*cfg, handler)
+	assert.Equal(t, "127.0.0.1:9091", server.Addr)
+	assert.Equal(t, defaultReadTimeout, server.ReadTimeout)
+	assert.Equal(t, 90*time.Second, server.WriteTimeout)
+	assert.Equal(t, defaultIdleTimeout, server.IdleTimeout)
+	assert.NotNil(t, server.Handler)
+
+	t.Setenv("EXPORTER_IDLE_TIMEOUT", "-1s")
+	_, err = LoadConfig("")
+	assert.Error(t, err)
+}
+
+func TestNewRegistry_RuntimeMetrics(t *testing.T) {
+	gathered := func(cfg Config) string {
+		var buf bytes.Buffer
+		assert.NoError(t, writeMetrics(&buf, newRegistry(cfg)))
+		return buf.String()
+	}
+
+	output := gathered(Config{})
+	assert.Contains(t, output, "go_goroutines")
+	assert.Contains(t, output, "go_memstats_alloc_bytes")
+
+	assert.NotContains(t, gathered(Config{DisableRuntimeMetrics: true}), "go_goroutines")
+}
+
+func TestMetricsHandler_Gzip(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "snowflake_test_gauge", Help: "Test gauge"})
+	registry.MustRegister(gauge)
+
+	get := func(handler http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	handler := metricsHandler(registry, registry, nil, true)
+
+	// Clients that accept gzip get it
+	rec := get(handler, "gzip")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(rec.Body)
+	if assert.NoError(t, err) {
+		body, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), "snowflake_test_gauge 0")
+	}
+
+	// and others plain text
+	rec = get(handler, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Body.String(), "snowflake_test_gauge 0")
+
+	// Disabled, responses are never compressed
+	rec = get(metricsHandler(prometheus.NewRegistry(), registry, nil, false), "gzip")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Body.String(), "snowflake_test_gauge 0")
+}
+
+func TestMetricsHandler_CancelsQueries(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// The query would take far longer than the client waits
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillDelayFor(5 * time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	registry := prometheus.NewRegistry()
+	handler := metricsHandler(registry, registry, []registration{{collector, nil}}, false)
+
+	// The client gives up on the scrape
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	handler.ServeHTTP(rec, req)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, rec.Body.String(), "snowflake_up 0")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMetricsHandler_ConcurrentRequests(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Requests arriving during a scrape share its result
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	registry := prometheus.NewRegistry()
+	handler := metricsHandler(registry, registry, []registration{{collector, nil}}, false)
+
+	bodies := make(chan string, 3)
+	for i := 0; i < cap(bodies); i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			bodies <- rec.Body.String()
+		}()
+	}
+	for i := 0; i < cap(bodies); i++ {
+		assert.Contains(t, <-bodies, `snowflake_warehouse_credits_used{size="",warehouse_name="COMPUTE_WH"} 10.5`)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestServe_TLS(t *testing.T) {
+	cert, certFile, keyFile := writeTestCert(t)
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "snowflake_test_gauge", Help: "Test gauge"})
+	registry.MustRegister(gauge)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, server, listener, certFile, keyFile)
+	}()
+
+	// Trust only the self-signed certificate
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get("https://" + listener.Addr().String() + "/metrics")
+	assert.NoError(t, err)
+	if err == nil {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NotNil(t, resp.TLS)
+		assert.Contains(t, string(body), "snowflake_test_gauge")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// runValidate connects to every configured account, runs each enabled query
+// once and prints how many samples it returned or why it failed. It returns
+// an error if any connection or query failed, so the configuration and grants
+// can be checked before Prometheus is pointed at the exporter.
+func runValidate(cfg Config) error {
+	failed := 0
+	for _, cc := range cfg.accounts() {
+		fmt.Fprintf(os.Stdout, "Account %s\n", cc.Account)
+		collector, err := newAccountCollector(cfg, cc)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "  connection failed: %v\n", err)
+			failed++
+			continue
+		}
+		if err := collector.validateQueries(os.Stdout); err != nil {
+			failed++
+		}
+		collector.Close()
+	}
+	if failed > 0 {
+		return fmt.Errorf("validation failed for %d of %d accounts", failed, len(cfg.accounts()))
+	}
+	return nil
+}
+
+// validateQueries runs each enabled metric group once, one at a time, and
+// writes its sample count or error to w.
+func (c *SnowflakeMetricsCollector) validateQueries(w io.Writer) error {
+	groups := c.collectedGroups()
+	failed := 0
+	for _, group := range groups {
+		samples, err := c.validateGroup(group)
+		if err != nil {
+			fmt.Fprintf(w, "  %-28s FAILED: %v\n", group.name, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "  %-28s %d samples\n", group.name, samples)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queries failed", failed, len(groups))
+	}
+	return nil
+}
+
+// validateGroup runs a metric group under the query timeout and counts the
+// samples it emits.
+func (c *SnowflakeMetricsCollector) validateGroup(group metricGroup) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.queryTimeout)
+	defer cancel()
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan int)
+	go func() {
+		samples := 0
+		for range ch {
+			samples++
+		}
+		done <- samples
+	}()
+	err := group.collect(ctx, ch)
+	close(ch)
+	return <-done, err
+}
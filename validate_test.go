@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflakeMetricsCollector_ValidateQueries(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_name", "total_credits", "compute_credits", "cloud_services_credits", "warehouse_size"}).
+			AddRow("COMPUTE_WH", 10.5, nil, nil, nil).
+			AddRow("REPORTING_WH", 2, nil, nil, nil))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.enabledGroups["storage_bytes"] = true
+
+	var out bytes.Buffer
+	assert.NoError(t, collector.validateQueries(&out))
+	assert.Contains(t, out.String(), "warehouse_credits            6 samples")
+	assert.Contains(t, out.String(), "storage_bytes                0 samples")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSnowflakeMetricsCollector_ValidateQueriesFailure(t *testing.T) {
+	// Create a sqlmock database
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Every query runs even after one fails
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT warehouse_name, SUM\\(credits_used\\) as total_credits").
+		WillReturnError(fmt.Errorf("insufficient privileges"))
+	mock.ExpectQuery("SELECT database_name, storage_bytes").
+		WillReturnRows(sqlmock.NewRows([]string{"database_name", "storage_bytes"}).
+			AddRow("PROD_DB", 1024))
+
+	collector := newSnowflakeMetricsCollector(db)
+	collector.enabledGroups = onlyGroup("warehouse_credits")
+	collector.enabledGroups["storage_bytes"] = true
+
+	var out bytes.Buffer
+	assert.EqualError(t, collector.validateQueries(&out), "1 of 2 queries failed")
+	assert.Contains(t, out.String(), "warehouse_credits            FAILED: insufficient privileges")
+	assert.Contains(t, out.String(), "storage_bytes                1 samples")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunValidate_ConnectionFailure(t *testing.T) {
+	cfg := Config{
+		Connection: connectionConfig{
+			Account:        "myaccount",
+			User:           "exporter",
+			PrivateKeyPath: filepath.Join(t.TempDir(), "missing.p8"),
+		},
+	}
+	assert.Error(t, runValidate(cfg))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// version and revision identify the build. They are set at build time with
+// -ldflags "-X main.version=... -X main.revision=...".
+var (
+	version  = "dev"
+	revision = "unknown"
+)
+
+// newBuildInfo returns the snowflake_exporter_build_info metric, a constant 1
+// labeled with the exporter's version, revision and Go version.
+func newBuildInfo() prometheus.Collector {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "snowflake_exporter_build_info",
+			Help: "A metric with a constant '1' value labeled by the version, revision and Go version the exporter was built with",
+			ConstLabels: prometheus.Labels{
+				"version":    version,
+				"revision":   revision,
+				"go_version": runtime.Version(),
+			},
+		},
+		func() float64 { return 1 },
+	)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildInfo(t *testing.T) {
+	expected := `
+		# HELP snowflake_exporter_build_info A metric with a constant '1' value labeled by the version, revision and Go version the exporter was built with
+		# TYPE snowflake_exporter_build_info gauge
+		snowflake_exporter_build_info{go_version="` + runtime.Version() + `",revision="unknown",version="dev"} 1
+	`
+	err := testutil.CollectAndCompare(newBuildInfo(), strings.NewReader(expected))
+	assert.NoError(t, err)
+}